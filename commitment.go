@@ -0,0 +1,80 @@
+package main
+
+import (
+	"runtime"
+)
+
+// VectorCommitment is a scheme for committing to a vector of rows of field
+// elements and later proving the rows at chosen positions. The prover and the
+// FRI code only go through this interface, so the tree shape, the cap height
+// and the hash function can change without touching them.
+type VectorCommitment interface {
+	Commit(rows RowStorage) CommittedVector
+	// Verify checks that rows[i] is the row at opening.Proof.Indices[i] of
+	// the vector committed to by commitment.
+	Verify(commitment Digest, opening VectorOpening, rows [][]FiniteFieldElement) bool
+}
+
+// CommittedVector is the prover's side of a commitment: the digest sent to
+// the verifier and the data needed to open it.
+type CommittedVector interface {
+	Commitment() Digest
+	Open(indices []int) VectorOpening
+}
+
+// VectorOpening proves the rows at Proof.Indices. Cap holds the nodes the
+// authentication paths stop at, checked against the commitment itself.
+type VectorOpening struct {
+	Cap   []Digest
+	Proof MerkleMultiProof
+}
+
+// MerkleCommitment commits with a Merkle tree over Hasher, revealing the cap
+// of height CapHeight with every opening. Salted trees hide the rows that
+// are never opened.
+type MerkleCommitment struct {
+	Hasher    Hasher
+	CapHeight int
+	Salted    bool
+}
+
+// Commit keeps the rows, not a copy, to answer openings. The nodes are held
+// in memory.
+func (m MerkleCommitment) Commit(rows RowStorage) CommittedVector {
+	nodes := make(MemoryDigestStorage, MerkleNodeCount(rows.Len()))
+	if m.Salted {
+		return committedMerkleTree{tree: NewSaltedStoredMerkleTree(rows, nodes, m.Hasher, runtime.GOMAXPROCS(0)), capHeight: m.CapHeight}
+	}
+	return committedMerkleTree{tree: NewStoredMerkleTree(rows, nodes, m.Hasher, runtime.GOMAXPROCS(0)), capHeight: m.CapHeight}
+}
+
+// Verify rejects openings whose cap is not of height CapHeight, or the whole
+// top level for trees too small to have such a cap.
+func (m MerkleCommitment) Verify(commitment Digest, opening VectorOpening, rows [][]FiniteFieldElement) bool {
+	if m.Salted != (len(opening.Proof.Salts) > 0) {
+		return false
+	}
+	if len(opening.Cap) != min(1<<m.CapHeight, 1<<merkleDepth(opening.Proof.LeafCount)) {
+		return false
+	}
+	return VerifyCap(opening.Cap, opening.Proof.LeafCount, commitment, m.Hasher) &&
+		VerifyCappedManyRows(opening.Proof, rows, opening.Cap, m.Hasher)
+}
+
+type committedMerkleTree struct {
+	tree      *MerkleTree
+	capHeight int
+}
+
+func (c committedMerkleTree) Commitment() Digest {
+	return c.tree.Commitment(c.capHeight)
+}
+
+func (c committedMerkleTree) Open(indices []int) VectorOpening {
+	return VectorOpening{Cap: c.tree.Cap(c.capHeight), Proof: c.tree.CappedOpenMany(indices, c.capHeight)}
+}
+
+// CommitColumn commits to a single column, one element per leaf.
+func CommitColumn(vc VectorCommitment, column ElementStorage) CommittedVector {
+	return vc.Commit(StoredRows{Values: column, Width: 1})
+}
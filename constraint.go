@@ -7,38 +7,18 @@ import (
 
 var generator FiniteFieldElement = FiniteFieldElement{Value: big.NewInt(5), Field: DefaultField}
 
-var g FiniteFieldElement = generator.Exp(FiniteFieldElement{
-	Value: big.NewInt(3 * (1 << 20)),
-	Field: DefaultField,
-})
-
-func Values() []FiniteFieldElement {
-
-	var genExp []FiniteFieldElement
-	var b FiniteFieldElement = One
-	for i := 0; i < 1024; i++ {
-		genExp = append(genExp, b)
-		b = b.Mul(g)
-	}
-	b = One
-	for i := 0; i < 1023; i++ {
-		if !b.IsEqual(genExp[i]) {
-			panic("The i-th place in G is not equal to the i-th power of g.")
-		}
-		b = b.Mul(g)
-		if b.IsEqual(One) {
-			panic("invalid")
-		}
-	}
-	return genExp
-}
+var g FiniteFieldElement = TraceDomain().Generator
 
-func fibSequence() []FiniteFieldElement {
+// traceLength is the number of rows of the FibonacciSq trace.
+const traceLength = 1023
 
-	sequence := make([]FiniteFieldElement, 1023)
+// fibSequence returns the first n elements of the FibonacciSq sequence.
+func fibSequence(n int) []FiniteFieldElement {
+
+	sequence := make([]FiniteFieldElement, n)
 	sequence[0] = One
 	sequence[1] = FiniteFieldElement{Value: big.NewInt(3141592), Field: DefaultField}
-	for i := 2; i < 1023; i++ {
+	for i := 2; i < n; i++ {
 		sequence[i] = (sequence[i-1].Mul(sequence[i-1])).Add(sequence[i-2].Mul(sequence[i-2]))
 	}
 	return sequence
@@ -48,10 +28,8 @@ func FirstConstraint(poly Polynomial) Polynomial {
 	onePoly := Polynomial{coeffs: []FiniteFieldElement{One}}
 	numer1 := poly.Sub(onePoly)
 	negX := []FiniteFieldElement{One.Negate(), One}
-	evalResult := numer1.Evaluate(One)
-
-	if !evalResult.IsZero() {
-		fmt.Println("numer1(1) is not zero! Value:", evalResult.Value)
+	if rows := ConstraintViolations(numer1, 0, 0); len(rows) > 0 {
+		fmt.Println("first constraint violated at trace rows", rows)
 	}
 	constraint1, _ := poly.Divide(Polynomial{coeffs: negX})
 	return constraint1
@@ -62,25 +40,22 @@ func SecondConstraint(poly Polynomial) Polynomial {
 	num1 := poly.Sub(Polynomial{coeffs: []FiniteFieldElement{constN}})
 	constD := FiniteFieldElement{Value: g.Exp(FiniteFieldElement{Value: big.NewInt(1022), Field: DefaultField}).Value, Field: DefaultField}
 	denom1 := Polynomial{coeffs: x}.Sub(Polynomial{coeffs: []FiniteFieldElement{constD}})
+	if rows := ConstraintViolations(num1, 1022, 1022); len(rows) > 0 {
+		fmt.Println("second constraint violated at trace rows", rows)
+	}
 
 	constraint2, _ := num1.Divide(denom1)
 	return constraint2
 }
 func ThirdConstraint(poly Polynomial) Polynomial {
 
-	onePoly := Polynomial{coeffs: []FiniteFieldElement{One}}
-	x := []FiniteFieldElement{Zero, One}
-	g2X := Polynomial{coeffs: []FiniteFieldElement{Zero, g.Exp(Two)}}
-	first := poly.Compose(g2X)
-
-	gX := Polynomial{coeffs: []FiniteFieldElement{Zero, g}}
-	second := poly.Compose(gX).Exp(Two)
-
-	third := poly.Exp(Two)
-
-	numer3 := first.Sub(second).Sub(third)
+	transition := NewTraceCell(0, 2).Sub(NewTraceCell(0, 1).Exp(2)).Sub(NewTraceCell(0, 0).Exp(2))
+	numer3 := transition.Substitute([]Polynomial{poly}, g)
+	if rows := ConstraintViolations(numer3, 0, 1020); len(rows) > 0 {
+		fmt.Println("third constraint violated at trace rows", rows)
+	}
 
-	k := Polynomial{coeffs: x}.Exp(FiniteFieldElement{Value: big.NewInt(1024), Field: DefaultField}).Sub(onePoly)
+	k := TraceDomain().Vanishing()
 	g1021 := Polynomial{coeffs: []FiniteFieldElement{g.Exp(FiniteFieldElement{Value: big.NewInt(1021), Field: DefaultField}).Negate(), One}}
 	g1022 := Polynomial{coeffs: []FiniteFieldElement{g.Exp(FiniteFieldElement{Value: big.NewInt(1022), Field: DefaultField}).Negate(), One}}
 	g1023 := Polynomial{coeffs: []FiniteFieldElement{g.Exp(FiniteFieldElement{Value: big.NewInt(1023), Field: DefaultField}).Negate(), One}}
@@ -95,9 +70,9 @@ func ThirdConstraint(poly Polynomial) Polynomial {
 }
 func CompositionPolynomial(ch *Channel, c1, c2, c3 Polynomial) Polynomial {
 
-	alpha0 := ch.ReceiveRandomFieldElement()
-	alpha1 := ch.ReceiveRandomFieldElement()
-	alpha2 := ch.ReceiveRandomFieldElement()
+	alpha0 := ch.SqueezeField("alpha0")
+	alpha1 := ch.SqueezeField("alpha1")
+	alpha2 := ch.SqueezeField("alpha2")
 	t0 := c1.ScalarMul(alpha0)
 	t1 := c2.ScalarMul(alpha1)
 	t2 := c3.ScalarMul(alpha2)
@@ -107,3 +82,18 @@ func CompositionPolynomial(ch *Channel, c1, c2, c3 Polynomial) Polynomial {
 
 	return cp
 }
+
+// ConstraintViolations returns the trace rows from first to last whose
+// points of the trace domain are not roots of numerator, i.e. the rows on
+// which the constraint fails.
+func ConstraintViolations(numerator Polynomial, first, last int) []int {
+	var rows []int
+	point := TraceDomain().Element(first)
+	for row := first; row <= last; row++ {
+		if !numerator.Evaluate(point).IsZero() {
+			rows = append(rows, row)
+		}
+		point = point.Mul(g)
+	}
+	return rows
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Corrupting row 500 breaks the transition constraint at the three rows
+// whose window holds it, and leaves the boundary constraints alone.
+func TestConstraintViolationsCorruptedRow(t *testing.T) {
+	trace := fibSequence(traceLength)
+	trace[500] = DefaultField.NewFieldElement(big.NewInt(7))
+	poly := TraceDomain().InterpolatePrefix(trace)
+
+	transition := NewTraceCell(0, 2).Sub(NewTraceCell(0, 1).Exp(2)).Sub(NewTraceCell(0, 0).Exp(2))
+	rows := ConstraintViolations(transition.Substitute([]Polynomial{poly}, g), 0, 1020)
+	if len(rows) != 3 || rows[0] != 498 || rows[1] != 499 || rows[2] != 500 {
+		t.Errorf("transition violated at rows %v, want [498 499 500]", rows)
+	}
+	if rows := ConstraintViolations(poly.Sub(Polynomial{coeffs: []FiniteFieldElement{One}}), 0, 0); len(rows) != 0 {
+		t.Errorf("first constraint violated at rows %v", rows)
+	}
+	last := Polynomial{coeffs: []FiniteFieldElement{DefaultField.NewFieldElement(big.NewInt(2338775057))}}
+	if rows := ConstraintViolations(poly.Sub(last), 1022, 1022); len(rows) != 0 {
+		t.Errorf("second constraint violated at rows %v", rows)
+	}
+}
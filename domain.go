@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math/big"
+)
+
+// Domain is the multiplicative coset Offset·<Generator> of Size elements.
+// Element i is Offset·Generator^i.
+type Domain struct {
+	Size      int
+	Generator FiniteFieldElement
+	Offset    FiniteFieldElement
+}
+
+func NewSubgroupDomain(size int) Domain {
+	return NewCosetDomain(size, One)
+}
+
+func NewCosetDomain(size int, offset FiniteFieldElement) Domain {
+	if !dividesGroupOrder(size) {
+		panic("domain size does not divide the multiplicative group order")
+	}
+	order := new(big.Int).Sub(DefaultFieldSize, big.NewInt(1))
+	quotient := new(big.Int).Div(order, big.NewInt(int64(size)))
+	if offset.IsZero() {
+		panic("domain offset must be non-zero")
+	}
+	return Domain{
+		Size:      size,
+		Generator: generator.Exp(FiniteFieldElement{Value: quotient, Field: DefaultField}),
+		Offset:    offset,
+	}
+}
+
+// TraceDomain is the smallest subgroup holding the trace.
+func TraceDomain() Domain {
+	return NewSubgroupDomain(SmoothDomainSize(traceLength))
+}
+
+func (d Domain) Element(i int) FiniteFieldElement {
+	i %= d.Size
+	if i < 0 {
+		i += d.Size
+	}
+	return d.Offset.Mul(d.Generator.Exp(FiniteFieldElement{Value: big.NewInt(int64(i)), Field: DefaultField}))
+}
+
+func (d Domain) Elements() []FiniteFieldElement {
+	elements := make([]FiniteFieldElement, d.Size)
+	x := d.Offset
+	for i := range elements {
+		elements[i] = x
+		x = x.Mul(d.Generator)
+	}
+	return elements
+}
+
+func (d Domain) Contains(x FiniteFieldElement) bool {
+	n := FiniteFieldElement{Value: big.NewInt(int64(d.Size)), Field: DefaultField}
+	return x.Division(d.Offset).Exp(n).IsEqual(One)
+}
+
+// Vanishing returns X^Size - Offset^Size, the polynomial whose roots are
+// exactly the elements of d.
+func (d Domain) Vanishing() Polynomial {
+	n := FiniteFieldElement{Value: big.NewInt(int64(d.Size)), Field: DefaultField}
+	coeffs := make([]FiniteFieldElement, d.Size+1)
+	for i := range coeffs {
+		coeffs[i] = Zero
+	}
+	coeffs[0] = d.Offset.Exp(n).Negate()
+	coeffs[d.Size] = One
+	return newPolynomial(coeffs)
+}
+
+// LagrangeBasis evaluates every Lagrange basis polynomial of d at x, so that
+// the interpolant of values over d evaluates at x to sum(values[i]·L[i]).
+// Off the domain it uses L_i(x) = Z(x)·x_i / (Size·Offset^Size·(x - x_i)).
+func (d Domain) LagrangeBasis(x FiniteFieldElement) []FiniteFieldElement {
+	basis := make([]FiniteFieldElement, d.Size)
+	elements := d.Elements()
+	for i := range basis {
+		basis[i] = Zero
+	}
+	for i, xi := range elements {
+		if x.IsEqual(xi) {
+			basis[i] = One
+			return basis
+		}
+	}
+	n := FiniteFieldElement{Value: big.NewInt(int64(d.Size)), Field: DefaultField}
+	offsetN := d.Offset.Exp(n)
+	scale := x.Exp(n).Sub(offsetN).Division(n.Mul(offsetN))
+	for i, xi := range elements {
+		basis[i] = scale.Mul(xi).Division(x.Sub(xi))
+	}
+	return basis
+}
+
+// Square returns the domain of squares of d, which is half the size. It is
+// the evaluation domain of the next FRI layer.
+func (d Domain) Square() Domain {
+	if d.Size%2 != 0 {
+		panic("cannot square a domain of odd size")
+	}
+	return Domain{
+		Size:      d.Size / 2,
+		Generator: d.Generator.Mul(d.Generator),
+		Offset:    d.Offset.Mul(d.Offset),
+	}
+}
+
+// MapIndex returns the index in to of Element(i)^(d.Size/to.Size), where to
+// is obtained from d by repeated squaring.
+func (d Domain) MapIndex(i int, to Domain) int {
+	if to.Size == 0 || d.Size%to.Size != 0 {
+		panic("target domain is not a power of the source domain")
+	}
+	return i % to.Size
+}
+
+// Evaluate evaluates p on every element of d using an NTT.
+func (d Domain) Evaluate(p Polynomial) []FiniteFieldElement {
+	coeffs := make([]FiniteFieldElement, d.Size)
+	for i := range coeffs {
+		coeffs[i] = Zero
+	}
+	// p(Offset·x) = sum c_i·Offset^i·x^i, and x^i only depends on i mod Size.
+	offsetI := One
+	for i, c := range p.coeffs {
+		coeffs[i%d.Size] = coeffs[i%d.Size].Add(c.Mul(offsetI))
+		offsetI = offsetI.Mul(d.Offset)
+	}
+	return NTT(coeffs, d.Generator)
+}
+
+// Interpolate returns the polynomial of degree less than Size that takes the
+// given values on the elements of d.
+func (d Domain) Interpolate(values []FiniteFieldElement) Polynomial {
+	if len(values) != d.Size {
+		panic("number of values does not match domain size")
+	}
+	coeffs := InverseNTT(values, d.Generator)
+	offsetInv := d.Offset.Inverse()
+	offsetI := One
+	for i := range coeffs {
+		coeffs[i] = coeffs[i].Mul(offsetI)
+		offsetI = offsetI.Mul(offsetInv)
+	}
+	return newPolynomial(coeffs)
+}
+
+// InterpolatePrefix returns the polynomial of degree less than len(values)
+// that takes values[i] on Element(i), for a trace shorter than the domain.
+// It interpolates the values padded with zeros and reduces the result modulo
+// the polynomial vanishing on the first len(values) elements.
+func (d Domain) InterpolatePrefix(values []FiniteFieldElement) Polynomial {
+	if len(values) > d.Size {
+		panic("more values than domain elements")
+	}
+	padded := make([]FiniteFieldElement, d.Size)
+	copy(padded, values)
+	tail := Polynomial{coeffs: []FiniteFieldElement{One}}
+	for i := len(values); i < d.Size; i++ {
+		padded[i] = Zero
+		tail = tail.Mul(Polynomial{coeffs: []FiniteFieldElement{d.Element(i).Negate(), One}})
+	}
+	prefix, _ := d.Vanishing().Divide(tail)
+	_, remainder := d.Interpolate(padded).Divide(prefix)
+	return remainder
+}
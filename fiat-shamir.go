@@ -1,66 +1,396 @@
 package main
 
 import (
-	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
-	"runtime"
+	"math/bits"
+	"strconv"
 	"strings"
 )
 
+// ProtocolID names the proof system and its version. It is absorbed before
+// anything else, so transcripts of different protocols never collide.
+const ProtocolID = "fibonacci-sq-stark/v1"
+
+// Absorbed messages, squeezed challenges and proof-of-work hashes are domain
+// separated.
+const (
+	absorbTag  byte = 0x00
+	squeezeTag byte = 0x01
+	grindTag   byte = 0x02
+)
+
+// transcript holds the Fiat-Shamir state. The prover's Channel and the
+// verifier's VerifierChannel both drive one, so given the same messages they
+// derive the same challenges.
+//
+// Every operation hashes the current state, a tag, the label and, for
+// absorbs, the data, each variable-length part prefixed by its length, so
+// no two different sequences of operations hash the same bytes.
+type transcript struct {
+	state  Digest
+	hasher Hasher
+}
+
+func newTranscript(protocol string, hasher Hasher) transcript {
+	t := transcript{hasher: hasher}
+	t.absorb("protocol", []byte(protocol))
+	t.absorb("hash", []byte(hasher.Name()))
+	return t
+}
+
+func appendLabel(data []byte, tag byte, label string) []byte {
+	data = append(data, tag)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(label)))
+	return append(data, label...)
+}
+
+func (t *transcript) absorb(label string, message []byte) {
+	data := appendLabel(append([]byte{}, t.state[:]...), absorbTag, label)
+	data = binary.BigEndian.AppendUint64(data, uint64(len(message)))
+	t.state = t.hasher.Hash(append(data, message...))
+}
+
+// squeeze returns the next challenge digest and moves the state past it.
+func (t *transcript) squeeze(label string) Digest {
+	t.state = t.hasher.Hash(appendLabel(append([]byte{}, t.state[:]...), squeezeTag, label))
+	return t.state
+}
+
+// squeezeInt returns a uniform integer in [min, max] by rejection sampling:
+// it keeps the low bits of a squeezed digest needed to cover the range and
+// squeezes again while the value falls outside it. Reducing a digest modulo
+// the range size instead would favour small values. Each attempt succeeds
+// with probability above 1/2, and always when the range size is a power of
+// two.
+func (t *transcript) squeezeInt(label string, min, max *big.Int) *big.Int {
+	rangeSize := new(big.Int).Sub(max, min)
+	rangeSize.Add(rangeSize, big.NewInt(1))
+	width := new(big.Int).Sub(rangeSize, big.NewInt(1)).BitLen()
+	if rangeSize.Sign() <= 0 || width > 8*len(Digest{}) {
+		panic("challenge range is empty or wider than a digest")
+	}
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(width)), big.NewInt(1))
+	for {
+		d := t.squeeze(label)
+		candidate := new(big.Int).SetBytes(d[:])
+		candidate.And(candidate, mask)
+		if candidate.Cmp(rangeSize) < 0 {
+			return candidate.Add(candidate, min)
+		}
+	}
+}
+
+// squeezeIndices draws n indices in [0, size) that are distinct modulo
+// classes, skipping draws whose class is already taken, so every index is
+// uniform given the ones before it. FRI queries use the cosets of the first
+// layer as classes, since indices in the same coset open the same leaves.
+func (t *transcript) squeezeIndices(label string, n, size, classes int) []int {
+	if classes <= 0 || size%classes != 0 || n > classes {
+		panic("cannot draw that many indices in distinct classes")
+	}
+	max := big.NewInt(int64(size - 1))
+	seen := map[int]bool{}
+	var indices []int
+	for len(indices) < n {
+		idx := int(t.squeezeInt(label, big.NewInt(0), max).Int64())
+		if !seen[idx%classes] {
+			seen[idx%classes] = true
+			indices = append(indices, idx)
+		}
+	}
+	return indices
+}
+
+func formatIndices(indices []int) string {
+	parts := make([]string, len(indices))
+	for i, idx := range indices {
+		parts[i] = strconv.Itoa(idx)
+	}
+	return strings.Join(parts, ",")
+}
+
+// MaxGrindingDifficulty caps the proof-of-work difficulty at what a prover
+// can afford. At d bits the search takes about 2^d hashes, and the chance
+// that no 64-bit nonce meets the difficulty, about exp(-2^(64-d)), is
+// negligible up to this cap.
+const MaxGrindingDifficulty = 32
+
+func checkGrindingDifficulty(difficulty int) error {
+	if difficulty < 0 || difficulty > MaxGrindingDifficulty {
+		return fmt.Errorf("grinding difficulty %d outside [0, %d]", difficulty, MaxGrindingDifficulty)
+	}
+	return nil
+}
+
+// grindingOK reports whether hashing the current state with nonce gives at
+// least difficulty leading zero bits.
+func (t *transcript) grindingOK(nonce uint64, difficulty int) bool {
+	data := append(append([]byte{}, t.state[:]...), grindTag)
+	d := t.hasher.Hash(binary.BigEndian.AppendUint64(data, nonce))
+	return leadingZeroBits(d) >= difficulty
+}
+
+func leadingZeroBits(d Digest) int {
+	n := 0
+	for _, b := range d {
+		n += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return n
+}
+
+// fieldMax is the largest value of a field challenge.
+var fieldMax = new(big.Int).Sub(DefaultFieldSize, big.NewInt(1))
+
+func digestsBytes(digests []Digest) []byte {
+	var data []byte
+	for _, d := range digests {
+		data = append(data, d[:]...)
+	}
+	return data
+}
+
+func fieldsBytes(values []FiniteFieldElement) []byte {
+	var data []byte
+	for _, v := range values {
+		data = append(data, v.Bytes()...)
+	}
+	return data
+}
+
+// Channel is the prover's side of the transcript. It records every message
+// and challenge in the proof as "label:value", with messages hex encoded.
 type Channel struct {
-	state string
+	transcript
 	proof []string
 }
 
-func NewChannel() *Channel {
-	stateHex := "0"
+// NewChannel starts a transcript for protocol using hasher. Both are
+// recorded as the header of the proof.
+func NewChannel(protocol string, hasher Hasher) *Channel {
 	return &Channel{
-		state: stateHex,
-		proof: []string{},
+		transcript: newTranscript(protocol, hasher),
+		proof:      []string{"protocol:" + protocol, "hash:" + hasher.Name()},
 	}
 }
 
-func (c *Channel) Send(s string) {
-	input := c.state + s
-	hash := sha256.Sum256([]byte(input))
-	c.state = hex.EncodeToString(hash[:])
+func (c *Channel) AbsorbBytes(label string, data []byte) {
+	c.absorb(label, data)
+	c.proof = append(c.proof, label+":"+hex.EncodeToString(data))
+}
 
-	pc, _, _, _ := runtime.Caller(0)
-	funcName := runtime.FuncForPC(pc).Name()
-	parts := strings.Split(funcName, ".")
-	callerName := parts[len(parts)-1]
-	c.proof = append(c.proof, fmt.Sprintf("%s:%s", callerName, s))
+func (c *Channel) AbsorbCommitment(label string, commitment Digest) {
+	c.AbsorbBytes(label, commitment[:])
 }
 
-func (c *Channel) ReceiveRandomInt(min, max *big.Int) *big.Int {
+func (c *Channel) AbsorbDigests(label string, digests []Digest) {
+	c.AbsorbBytes(label, digestsBytes(digests))
+}
 
-	stateInt, _ := new(big.Int).SetString(c.state, 16)
+func (c *Channel) AbsorbFields(label string, values ...FiniteFieldElement) {
+	c.AbsorbBytes(label, fieldsBytes(values))
+}
 
-	rangeSize := new(big.Int).Sub(max, min)
-	rangeSize.Add(rangeSize, big.NewInt(1))
+// Grind searches for a proof-of-work nonce for the current state whose hash
+// has difficulty leading zero bits and absorbs it. Every extra bit doubles
+// the expected prover work and the cost to a cheating prover of redrawing
+// the challenges that follow.
+func (c *Channel) Grind(label string, difficulty int) uint64 {
+	if err := checkGrindingDifficulty(difficulty); err != nil {
+		panic(err)
+	}
+	nonce := uint64(0)
+	for !c.grindingOK(nonce, difficulty) {
+		if nonce == math.MaxUint64 {
+			panic("no grinding nonce found")
+		}
+		nonce++
+	}
+	c.AbsorbBytes(label, binary.BigEndian.AppendUint64(nil, nonce))
+	return nonce
+}
 
-	modResult := new(big.Int).Mod(stateInt, rangeSize)
-	result := new(big.Int).Add(modResult, min)
-	hash := sha256.Sum256([]byte(c.state))
-	c.state = hex.EncodeToString(hash[:])
+func (c *Channel) SqueezeInt(label string, min, max *big.Int) *big.Int {
+	result := c.squeezeInt(label, min, max)
+	c.proof = append(c.proof, label+":"+result.String())
+	return result
+}
 
-	pc, _, _, _ := runtime.Caller(1)
-	funcName := runtime.FuncForPC(pc).Name()
-	parts := strings.Split(funcName, ".")
-	callerName := parts[len(parts)-1]
+// SqueezeIndices draws n query indices in [0, size) that are distinct modulo
+// classes.
+func (c *Channel) SqueezeIndices(label string, n, size, classes int) []int {
+	indices := c.squeezeIndices(label, n, size, classes)
+	c.proof = append(c.proof, label+":"+formatIndices(indices))
+	return indices
+}
 
-	c.proof = append(c.proof, fmt.Sprintf("%s:%s", callerName, result.String()))
+func (c *Channel) SqueezeField(label string) FiniteFieldElement {
+	return DefaultField.NewFieldElement(c.SqueezeInt(label, big.NewInt(0), fieldMax))
+}
 
-	return result
+// VerifierChannel replays a proof recorded by Channel. It absorbs the
+// prover's messages in order and derives the challenges itself, rejecting
+// the proof if a label or a recorded challenge differs.
+type VerifierChannel struct {
+	transcript
+	proof []string
+}
+
+func NewVerifierChannel(protocol string, proof []string) (*VerifierChannel, error) {
+	if len(proof) < 2 {
+		return nil, errors.New("proof has no header")
+	}
+	if proof[0] != "protocol:"+protocol {
+		return nil, fmt.Errorf("proof header %q does not match protocol %q", proof[0], protocol)
+	}
+	name, ok := strings.CutPrefix(proof[1], "hash:")
+	if !ok {
+		return nil, fmt.Errorf("proof header %q does not name a hash function", proof[1])
+	}
+	hasher, ok := HasherByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown hash function %q", name)
+	}
+	return &VerifierChannel{transcript: newTranscript(protocol, hasher), proof: proof[2:]}, nil
+}
+
+// next pops the next proof entry, which must carry label, and returns its
+// value.
+func (v *VerifierChannel) next(label string) (string, error) {
+	if len(v.proof) == 0 {
+		return "", fmt.Errorf("proof ended before %s", label)
+	}
+	entry := v.proof[0]
+	v.proof = v.proof[1:]
+	value, ok := strings.CutPrefix(entry, label+":")
+	if !ok {
+		return "", fmt.Errorf("expected %s, proof has %q", label, entry)
+	}
+	return value, nil
+}
+
+// ReadBytes reads the prover's next message, which must carry label, and
+// absorbs it.
+func (v *VerifierChannel) ReadBytes(label string) ([]byte, error) {
+	value, err := v.next(label)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", label, err)
+	}
+	v.absorb(label, data)
+	return data, nil
+}
+
+func (v *VerifierChannel) ReadCommitment(label string) (Digest, error) {
+	digests, err := v.ReadDigests(label)
+	if err != nil {
+		return Digest{}, err
+	}
+	if len(digests) != 1 {
+		return Digest{}, fmt.Errorf("%s: expected one digest, got %d", label, len(digests))
+	}
+	return digests[0], nil
+}
+
+func (v *VerifierChannel) ReadDigests(label string) ([]Digest, error) {
+	data, err := v.ReadBytes(label)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%len(Digest{}) != 0 {
+		return nil, fmt.Errorf("%s: %d bytes is not a whole number of digests", label, len(data))
+	}
+	digests := make([]Digest, len(data)/len(Digest{}))
+	for i := range digests {
+		copy(digests[i][:], data[i*len(Digest{}):])
+	}
+	return digests, nil
 }
 
-func (c *Channel) ReceiveRandomFieldElement() FiniteFieldElement {
-	min := big.NewInt(0)
-	max := new(big.Int).Sub(DefaultFieldSize, big.NewInt(1))
+func (v *VerifierChannel) ReadFields(label string) ([]FiniteFieldElement, error) {
+	data, err := v.ReadBytes(label)
+	if err != nil {
+		return nil, err
+	}
+	size := DefaultField.ElementSize()
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("%s: %d bytes is not a whole number of field elements", label, len(data))
+	}
+	values := make([]FiniteFieldElement, len(data)/size)
+	for i := range values {
+		value, ok := DefaultField.ElementFromBytes(data[i*size : (i+1)*size])
+		if !ok {
+			return nil, fmt.Errorf("%s: value %d is not a field element", label, i)
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// CheckGrinding reads the prover's proof-of-work nonce, checks it against
+// the current state and absorbs it.
+func (v *VerifierChannel) CheckGrinding(label string, difficulty int) error {
+	if err := checkGrindingDifficulty(difficulty); err != nil {
+		return err
+	}
+	value, err := v.next(label)
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(value)
+	if err != nil || len(data) != 8 {
+		return fmt.Errorf("%s: malformed nonce %q", label, value)
+	}
+	if !v.grindingOK(binary.BigEndian.Uint64(data), difficulty) {
+		return fmt.Errorf("%s: nonce does not have %d leading zero bits", label, difficulty)
+	}
+	v.absorb(label, data)
+	return nil
+}
+
+func (v *VerifierChannel) SqueezeInt(label string, min, max *big.Int) (*big.Int, error) {
+	result := v.squeezeInt(label, min, max)
+	recorded, err := v.next(label)
+	if err != nil {
+		return nil, err
+	}
+	if recorded != result.String() {
+		return nil, fmt.Errorf("proof records %s %s, transcript gives %s", label, recorded, result)
+	}
+	return result, nil
+}
+
+func (v *VerifierChannel) SqueezeIndices(label string, n, size, classes int) ([]int, error) {
+	indices := v.squeezeIndices(label, n, size, classes)
+	recorded, err := v.next(label)
+	if err != nil {
+		return nil, err
+	}
+	if recorded != formatIndices(indices) {
+		return nil, fmt.Errorf("proof records %s %s, transcript gives %s", label, recorded, formatIndices(indices))
+	}
+	return indices, nil
+}
+
+func (v *VerifierChannel) SqueezeField(label string) (FiniteFieldElement, error) {
+	num, err := v.SqueezeInt(label, big.NewInt(0), fieldMax)
+	if err != nil {
+		return FiniteFieldElement{}, err
+	}
+	return DefaultField.NewFieldElement(num), nil
+}
 
-	num := c.ReceiveRandomInt(min, max)
-	randomFieldElement := DefaultField.NewFieldElement(num)
-	return randomFieldElement
+// Done reports whether every entry of the proof has been read.
+func (v *VerifierChannel) Done() bool {
+	return len(v.proof) == 0
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// chiSquare returns the chi-square statistic of counts against the uniform
+// distribution.
+func chiSquare(counts []int, draws int) float64 {
+	expected := float64(draws) / float64(len(counts))
+	stat := 0.0
+	for _, c := range counts {
+		d := float64(c) - expected
+		stat += d * d / expected
+	}
+	return stat
+}
+
+// The transcript is deterministic, so each test sees the same draws on every
+// run. The bounds are the chi-square critical values at p = 0.001.
+func TestSqueezeIntUniform(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int64
+		bound float64
+	}{
+		// Six values need three bits, so a quarter of the draws are rejected.
+		{"six values", 6, 20.52},
+		// Eight values fit three bits exactly, so none are rejected.
+		{"eight values", 8, 24.32},
+		// 2^4 + 1 values need five bits, so almost half are rejected.
+		{"2^4+1 values", 17, 39.25},
+	}
+	for _, tt := range tests {
+		ch := NewChannel(ProtocolID, SHA256Hasher{})
+		const draws = 60000
+		counts := make([]int, tt.size)
+		for i := 0; i < draws; i++ {
+			v := ch.SqueezeInt("challenge", big.NewInt(10), big.NewInt(10+tt.size-1)).Int64()
+			if v < 10 || v >= 10+tt.size {
+				t.Fatalf("%s: %d out of range", tt.name, v)
+			}
+			counts[v-10]++
+		}
+		if stat := chiSquare(counts, draws); stat > tt.bound {
+			t.Errorf("%s: chi-square %.2f exceeds %.2f, counts %v", tt.name, stat, tt.bound, counts)
+		}
+	}
+}
+
+// A range whose size is a power of two takes exactly one squeeze per draw.
+func TestSqueezeIntPowerOfTwoSqueezesOnce(t *testing.T) {
+	drawn := newTranscript(ProtocolID, SHA256Hasher{})
+	squeezed := newTranscript(ProtocolID, SHA256Hasher{})
+	for i := 0; i < 100; i++ {
+		drawn.squeezeInt("challenge", big.NewInt(0), big.NewInt(15))
+		squeezed.squeeze("challenge")
+	}
+	if drawn.state != squeezed.state {
+		t.Fatal("drawing from 16 values rejected some squeezes")
+	}
+}
+
+// With as many indices as classes, every class is hit exactly once, and the
+// verifier draws the same indices.
+func TestSqueezeIndicesDistinctClasses(t *testing.T) {
+	const size, classes = 64, 8
+	ch := NewChannel(ProtocolID, SHA256Hasher{})
+	indices := ch.SqueezeIndices("query", classes, size, classes)
+	seen := map[int]bool{}
+	for _, idx := range indices {
+		if idx < 0 || idx >= size || seen[idx%classes] {
+			t.Fatalf("indices %v repeat a class or leave the range", indices)
+		}
+		seen[idx%classes] = true
+	}
+	v, err := NewVerifierChannel(ProtocolID, ch.proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.SqueezeIndices("query", classes, size, classes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSqueezeFieldUniform(t *testing.T) {
+	ch := NewChannel(ProtocolID, SHA256Hasher{})
+	const draws, buckets = 60000, 16
+	counts := make([]int, buckets)
+	for i := 0; i < draws; i++ {
+		v := ch.SqueezeField("alpha").Value
+		bucket := new(big.Int).Div(new(big.Int).Mul(v, big.NewInt(buckets)), DefaultFieldSize)
+		counts[bucket.Int64()]++
+	}
+	if stat := chiSquare(counts, draws); stat > 37.70 {
+		t.Errorf("chi-square %.2f exceeds 37.70, counts %v", stat, counts)
+	}
+}
+
+func TestSqueezeIntSingleValue(t *testing.T) {
+	ch := NewChannel(ProtocolID, SHA256Hasher{})
+	if v := ch.SqueezeInt("challenge", big.NewInt(3), big.NewInt(3)); v.Int64() != 3 {
+		t.Errorf("got %s, want 3", v)
+	}
+}
+
+func TestGrinding(t *testing.T) {
+	ch := NewChannel(ProtocolID, SHA256Hasher{})
+	ch.AbsorbCommitment("root", Digest{1})
+	ch.Grind("nonce", 12)
+
+	replay := func() *VerifierChannel {
+		v, err := NewVerifierChannel(ProtocolID, ch.proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.ReadCommitment("root"); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if err := replay().CheckGrinding("nonce", 12); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay().CheckGrinding("nonce", 24); err == nil {
+		t.Fatal("nonce accepted at a higher difficulty")
+	}
+	for _, difficulty := range []int{-1, MaxGrindingDifficulty + 1} {
+		if err := replay().CheckGrinding("nonce", difficulty); err == nil {
+			t.Errorf("difficulty %d accepted", difficulty)
+		}
+	}
+}
+
+// A verifier replaying the prover's transcript derives the same challenges,
+// nonce check and queries, and notices any change to what the prover sent
+// or recorded.
+func TestVerifierReplaysTranscript(t *testing.T) {
+	ch := NewChannel(ProtocolID, SHA256Hasher{})
+	ch.AbsorbCommitment("trace_root", Digest{1})
+	ch.AbsorbCommitment("composition_root", Digest{2})
+	alpha := ch.SqueezeField("alpha")
+	ch.Grind("nonce", 8)
+	indices := ch.SqueezeIndices("query", 4, 64, 16)
+
+	replay := func(proof []string) error {
+		v, err := NewVerifierChannel(ProtocolID, proof)
+		if err != nil {
+			return err
+		}
+		for _, label := range []string{"trace_root", "composition_root"} {
+			if _, err := v.ReadCommitment(label); err != nil {
+				return err
+			}
+		}
+		got, err := v.SqueezeField("alpha")
+		if err != nil {
+			return err
+		}
+		if !got.IsEqual(alpha) {
+			return fmt.Errorf("alpha = %s, want %s", got.Value, alpha.Value)
+		}
+		if err := v.CheckGrinding("nonce", 8); err != nil {
+			return err
+		}
+		queries, err := v.SqueezeIndices("query", 4, 64, 16)
+		if err != nil {
+			return err
+		}
+		if formatIndices(queries) != formatIndices(indices) {
+			return fmt.Errorf("queries %v, want %v", queries, indices)
+		}
+		if !v.Done() {
+			return errors.New("proof has unread entries")
+		}
+		return nil
+	}
+	if err := replay(ch.proof); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := map[string]string{
+		"alpha":      alpha.Add(One).Value.String(),
+		"trace_root": Digest{3}.String(),
+	}
+	for label, value := range changes {
+		tampered := append([]string{}, ch.proof...)
+		for i, entry := range tampered {
+			if strings.HasPrefix(entry, label+":") {
+				tampered[i] = label + ":" + value
+			}
+		}
+		if err := replay(tampered); err == nil {
+			t.Errorf("transcript with a changed %s accepted", label)
+		}
+	}
+	if err := replay(append(append([]string{}, ch.proof...), "extra:00")); err == nil {
+		t.Error("transcript with a trailing entry accepted")
+	}
+}
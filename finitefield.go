@@ -94,3 +94,24 @@ func (f FiniteFieldElement) Exp(q FiniteFieldElement) FiniteFieldElement {
 	t := new(big.Int).Exp(f.Value, q.Value, f.Field.Prime)
 	return FiniteFieldElement{Value: t, Field: f.Field}
 }
+
+// Bytes returns the canonical encoding of f: its reduced value as a
+// big-endian integer padded to the byte length of the field prime.
+func (f FiniteFieldElement) Bytes() []byte {
+	return f.Value.FillBytes(make([]byte, f.Field.ElementSize()))
+}
+
+// ElementSize is the length in bytes of the encoding returned by Bytes.
+func (f FiniteField) ElementSize() int {
+	return (f.Prime.BitLen() + 7) / 8
+}
+
+// ElementFromBytes decodes the encoding returned by Bytes, rejecting inputs
+// of the wrong length and values that are not reduced.
+func (f FiniteField) ElementFromBytes(b []byte) (FiniteFieldElement, bool) {
+	value := new(big.Int).SetBytes(b)
+	if len(b) != f.ElementSize() || value.Cmp(f.Prime) >= 0 {
+		return FiniteFieldElement{}, false
+	}
+	return FiniteFieldElement{Value: value, Field: f}, true
+}
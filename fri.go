@@ -1,90 +1,98 @@
 package main
 
-import (
-	"math/big"
-	"strings"
+// MerkleCapHeight is the height of the Merkle caps used in place of roots.
+// Every authentication path in the proof is that many hashes shorter, at the
+// cost of revealing the cap of each tree once.
+const MerkleCapHeight = 2
+
+// FRIFoldingFactor is the number of points of a FRI layer that fold into one
+// point of the next: 2, 4 or 8. Each such coset is one leaf of the layer's
+// commitment, so a query opens a single leaf per layer.
+const FRIFoldingFactor = 4
+
+// Each constant overflows uint, failing the build, unless FRIFoldingFactor
+// is 2, 4 or 8.
+const (
+	_ = uint(FRIFoldingFactor - 2)
+	_ = uint(8/FRIFoldingFactor*FRIFoldingFactor - 8)
 )
 
-func EvalDomain() []FiniteFieldElement {
-	exponent := new(big.Int).Mul(big.NewInt(3), new(big.Int).Lsh(big.NewInt(1), 30))
-	exponent = new(big.Int).Div(exponent, big.NewInt(8192))
-
-	var h_gen FiniteFieldElement = generator.Exp(FiniteFieldElement{
-		Value: exponent,
-		Field: DefaultField,
-	})
-	var h []FiniteFieldElement
-	for i := 0; i < 8192; i++ {
-		t := h_gen.Exp(FiniteFieldElement{Value: big.NewInt(int64(i)), Field: DefaultField})
-		h = append(h, t)
+// GrindingBits is the proof-of-work difficulty the prover must meet before
+// the query indices are drawn. Each bit makes retrying for lucky queries
+// twice as costly, so fewer queries reach the same security.
+const GrindingBits = 16
+
+// NumQueries is the number of points of the LDE domain at which the proof is
+// opened, no two in the same coset of the first FRI layer.
+const NumQueries = 3
+
+// nextFRIPolynomial writes p(X) = Σ X^i·p_i(X^F) for F = FRIFoldingFactor
+// and returns Σ beta^i·p_i.
+func nextFRIPolynomial(p Polynomial, beta FiniteFieldElement) Polynomial {
+	parts := make([][]FiniteFieldElement, FRIFoldingFactor)
+	for i, c := range p.coeffs {
+		parts[i%FRIFoldingFactor] = append(parts[i%FRIFoldingFactor], c)
 	}
-	var domain []FiniteFieldElement
-	for _, val := range h {
-		k := generator.Mul(val)
-		domain = append(domain, k)
+	result := Polynomial{}
+	betaI := One
+	for _, part := range parts {
+		result = result.Add(newPolynomial(part).ScalarMul(betaI))
+		betaI = betaI.Mul(beta)
 	}
-	return domain
+	return result
 }
 
-func nextFRIdomain(fri_domain []FiniteFieldElement) []FiniteFieldElement {
-	t := len(fri_domain)
-	newDomain := make([]FiniteFieldElement, t/2)
-	for i := 0; i < t/2; i++ {
-		newDomain[i] = fri_domain[i].Exp(Two)
+func NextFRILayer(poly Polynomial, domain Domain, Beta FiniteFieldElement) (Polynomial, Domain, []FiniteFieldElement) {
+	next_poly := nextFRIPolynomial(poly, Beta)
+	next_domain := domain
+	for i := 1; i < FRIFoldingFactor; i *= 2 {
+		next_domain = next_domain.Square()
 	}
-	return newDomain
+	nextLayer := next_domain.Evaluate(next_poly)
+	return next_poly, next_domain, nextLayer
 }
 
-func nextFRIPolynomial(p Polynomial, beta FiniteFieldElement) Polynomial {
-	oddPoly := OddCoeffs(p)
-	evenPoly := EvenCoeffs(p)
-	for i := range oddPoly.coeffs {
-		oddPoly.coeffs[i] = oddPoly.coeffs[i].Mul(beta)
-	}
-	result := evenPoly.Add(oddPoly)
-	return result
+// friCosets groups a layer into the cosets that fold to the same point of
+// the next layer: row j holds layer[j + t·len(layer)/F] for t < F. Rows are
+// read from the layer when asked for, so a stored layer stays in storage.
+type friCosets struct {
+	layer ElementStorage
 }
 
-func OddCoeffs(p Polynomial) Polynomial {
-	t := len(p.coeffs)
-	var oddCoeffs []FiniteFieldElement
-	for i := 1; i < t; i += 2 {
-		oddCoeffs = append(oddCoeffs, p.coeffs[i])
-	}
-	return Polynomial{coeffs: oddCoeffs}
+func (c friCosets) Len() int {
+	return c.layer.Len() / FRIFoldingFactor
 }
 
-func EvenCoeffs(p Polynomial) Polynomial {
-	t := len(p.coeffs)
-	var evenCoeffs []FiniteFieldElement
-	for i := 0; i < t; i += 2 {
-		evenCoeffs = append(evenCoeffs, p.coeffs[i])
+func (c friCosets) Row(j int) []FiniteFieldElement {
+	row := make([]FiniteFieldElement, FRIFoldingFactor)
+	for t := range row {
+		row[t] = c.layer.Get(j + t*c.Len())
 	}
-	return Polynomial{coeffs: evenCoeffs}
+	return row
 }
 
-func NextFRILayer(poly Polynomial, domain []FiniteFieldElement, Beta FiniteFieldElement) (Polynomial, []FiniteFieldElement, []FiniteFieldElement) {
-	next_poly := nextFRIPolynomial(poly, Beta)
-	next_domain := nextFRIdomain(domain)
-	var nextLayer []FiniteFieldElement
-	for _, val := range next_domain {
-		eval := next_poly.Evaluate(val)
-		nextLayer = append(nextLayer, eval)
-	}
-	return next_poly, next_domain, nextLayer
+// CommitFRILayer commits to a FRI layer with one leaf per folding coset.
+func CommitFRILayer(vc VectorCommitment, layer ElementStorage) CommittedVector {
+	return vc.Commit(friCosets{layer})
 }
 
-func FriCommit(cp Polynomial, domain []FiniteFieldElement, cp_eval []FiniteFieldElement, ch *Channel, cp_merkle [][]Node) ([]Polynomial, [][]FiniteFieldElement, [][]FiniteFieldElement, [][][]Node) {
+// FriCommit panics if cp does not have degree below the size of the trace
+// domain, since FRI would then fail on an honest proof.
+// Layers after the first are computed in memory.
+func FriCommit(cp Polynomial, domain Domain, cp_eval ElementStorage, ch *Channel, vc VectorCommitment, cp_commitment CommittedVector) ([]Polynomial, []Domain, []ElementStorage, []CommittedVector) {
+	if cp.Degree() >= TraceDomain().Size {
+		panic("composition polynomial degree exceeds the trace domain size")
+	}
 	var fripolys []Polynomial
 	fripolys = append(fripolys, cp)
-	var fridomains [][]FiniteFieldElement
+	var fridomains []Domain
 	fridomains = append(fridomains, domain)
-	var frilayers [][]FiniteFieldElement
+	var frilayers []ElementStorage
 	frilayers = append(frilayers, cp_eval)
-	var frimerkles [][][]Node
-	frimerkles = append(frimerkles, cp_merkle)
+	var fricommitments []CommittedVector
+	fricommitments = append(fricommitments, cp_commitment)
 	for fripolys[len(fripolys)-1].Degree() > 0 {
-		beta := ch.ReceiveRandomFieldElement()
+		beta := ch.SqueezeField("fri_beta")
 		t := len(fripolys)
 		k := len(fridomains)
 
@@ -92,72 +100,76 @@ func FriCommit(cp Polynomial, domain []FiniteFieldElement, cp_eval []FiniteField
 
 		fripolys = append(fripolys, nextPoly)
 		fridomains = append(fridomains, nextDomain)
-		frilayers = append(frilayers, nextLayer)
-		frimerkles = append(frimerkles, MerkleTree(nextLayer))
-		ch.Send(MerkleRoot(frimerkles[len(frimerkles)-1]).hash)
+		frilayers = append(frilayers, MemoryElementStorage(nextLayer))
+		// The last layer is constant and sent in the clear instead.
+		if nextPoly.Degree() > 0 {
+			fricommitments = append(fricommitments, CommitFRILayer(vc, MemoryElementStorage(nextLayer)))
+			ch.AbsorbCommitment("fri_layer_root", fricommitments[len(fricommitments)-1].Commitment())
+		}
 	}
-	t := FiniteFieldElement{Value: fripolys[len(fripolys)-1].coeffs[0].Value, Field: DefaultField}
-	ch.Send(t.Value.String())
-	return fripolys, fridomains, frilayers, frimerkles
+	t := fripolys[len(fripolys)-1].Coeff(0)
+	ch.AbsorbFields("fri_final_coeff", t)
+	return fripolys, fridomains, frilayers, fricommitments
 }
-func DecommitFriLayer(idx int, ch *Channel, friLayers [][]FiniteFieldElement, friMerkles [][][]Node) {
-	for i := 0; i < len(friLayers)-1; i++ {
-		layer := friLayers[i]
-		merkle := friMerkles[i]
-
-		length := len(layer)
-		idx = idx % length
-		sib_idx := (idx + length/2) % length
-		ch.Send(layer[idx].Value.String())
-		merkleidx := MerkleProof(merkle, idx)
-		l := len(merkleidx)
-		merkleidxreversed := make([]string, l)
-		for i := range merkleidx {
-			merkleidxreversed[i] = merkleidx[l-i-1]
-		}
-		merklequoted := strings.Join(merkleidxreversed, " ")
-		ch.Send(merklequoted)
-		ch.Send(layer[sib_idx].Value.String())
-		merkleproof := MerkleProof(merkle, sib_idx)
-		t := len(merkleproof)
-		merkleproofreversed := make([]string, t)
-		for i := range merkleproof {
-			merkleproofreversed[i] = merkleproof[t-1-i]
+
+// sendOpening sends the rows at positions followed by the opening that
+// proves them, under labels starting with name. Queries can still share a
+// position, in the trace or in a later FRI layer, so positions are
+// deduplicated and sorted first; a verifier derives the same list.
+func sendOpening(ch *Channel, name string, rows RowStorage, committed CommittedVector, positions []int) {
+	positions = uniqueSorted(positions)
+	var values []FiniteFieldElement
+	for _, pos := range positions {
+		values = append(values, rows.Row(pos)...)
+	}
+	ch.AbsorbFields(name+"_values", values...)
+	opening := committed.Open(positions)
+	if len(opening.Proof.Salts) > 0 {
+		var salts []byte
+		for _, salt := range opening.Proof.Salts {
+			salts = append(salts, salt...)
 		}
-		merklequoted2 := strings.Join(merkleproofreversed, " ")
-		ch.Send(merklequoted2)
+		ch.AbsorbBytes(name+"_salts", salts)
 	}
-	ch.Send(friLayers[len(friLayers)-1][0].Value.String())
+	ch.AbsorbDigests(name+"_cap", opening.Cap)
+	ch.AbsorbDigests(name+"_siblings", opening.Proof.Siblings)
 }
 
-func DecommitOnQuery(idx int, ch *Channel, poly Polynomial, friLayers [][]FiniteFieldElement, friMerkles [][][]Node) {
-	domain := EvalDomain()
-	f_eval := poly.EvaluateDomain(domain)
-	merkleTree := MerkleTree(f_eval)
-	if idx+16 >= len(f_eval) {
-		panic("idx is out of range")
-	}
-	ch.Send(f_eval[idx].Value.String())
-	firstMerkleProof := MerkleProof(merkleTree, idx)
-	ch.Send(strings.Join(firstMerkleProof, " "))
+func DecommitFriLayers(indices []int, ch *Channel, friLayers []ElementStorage, friCommitments []CommittedVector) {
+	for i := 0; i < len(friLayers)-1; i++ {
+		cosets := friCosets{friLayers[i]}
 
-	ch.Send(f_eval[idx+8].Value.String())
-	secondMerkleProof := MerkleProof(merkleTree, idx+8)
-	ch.Send(strings.Join(secondMerkleProof, " "))
+		var positions []int
+		for _, idx := range indices {
+			positions = append(positions, idx%cosets.Len())
+		}
+		sendOpening(ch, "fri_layer", cosets, friCommitments[i], positions)
+	}
+	ch.AbsorbFields("fri_last_value", friLayers[len(friLayers)-1].Get(0))
+}
 
-	ch.Send(f_eval[idx+16].Value.String())
-	thirdMerkleProof := MerkleProof(merkleTree, idx+16)
-	ch.Send(strings.Join(thirdMerkleProof, " "))
+// DecommitOnQueries opens the trace LDE, committed to by trace, at each query
+// and the two rows after it, then decommits the FRI layers. Consecutive
+// trace rows are Blowup points apart in the LDE.
+func DecommitOnQueries(indices []int, ch *Channel, lde LDEConfig, f_eval ElementStorage, trace CommittedVector, friLayers []ElementStorage, friCommitments []CommittedVector) {
+	var positions []int
+	for _, idx := range indices {
+		if idx < 0 || idx >= f_eval.Len() {
+			panic("idx is out of range")
+		}
+		// The domain is cyclic, so the next two trace rows wrap around.
+		for row := 0; row < 3; row++ {
+			positions = append(positions, (idx+row*lde.Blowup)%f_eval.Len())
+		}
+	}
+	sendOpening(ch, "trace", StoredRows{Values: f_eval, Width: 1}, trace, positions)
 
-	DecommitFriLayer(idx, ch, friLayers, friMerkles)
+	DecommitFriLayers(indices, ch, friLayers, friCommitments)
 }
 
-func DecommitFRI(ch *Channel, poly Polynomial, frilayers [][]FiniteFieldElement, frimerkles [][][]Node) {
-	lowerBound := big.NewInt(0)
-	upperBound := big.NewInt(8191 - 16)
-
-	for query := 0; query < 3; query++ {
-		t := ch.ReceiveRandomInt(lowerBound, upperBound)
-		DecommitOnQuery(int(t.Int64()), ch, poly, frilayers, frimerkles)
-	}
+func DecommitFRI(ch *Channel, lde LDEConfig, traceLDE ElementStorage, trace CommittedVector, frilayers []ElementStorage, fricommitments []CommittedVector) {
+	ch.Grind("grinding_nonce", GrindingBits)
+	// Queries in the same coset would open the same FRI leaves.
+	indices := ch.SqueezeIndices("query", NumQueries, traceLDE.Len(), traceLDE.Len()/FRIFoldingFactor)
+	DecommitOnQueries(indices, ch, lde, traceLDE, trace, frilayers, fricommitments)
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Every value of the next layer is determined by one coset of the current
+// layer: writing p(X) = Σ X^i·p_i(X^F), p_i(x^F) is (1/F)·Σ_t p(x_t)·x_t^(-i)
+// over the coset points x_t, and the next layer holds Σ beta^i·p_i(x^F).
+func TestNextFRILayerFoldsCosets(t *testing.T) {
+	coeffs := make([]FiniteFieldElement, 1023)
+	for i := range coeffs {
+		coeffs[i] = DefaultField.NewFieldElement(big.NewInt(int64(i*i*31 + 7)))
+	}
+	poly := NewPolyFromField(coeffs)
+	domain := DefaultLDEConfig().Domain(TraceDomain().Size)
+	beta := DefaultField.NewFieldElement(big.NewInt(12345))
+	_, nextDomain, next := NextFRILayer(poly, domain, beta)
+	cosets := friCosets{MemoryElementStorage(domain.Evaluate(poly))}
+	if cosets.Len() != len(next) || nextDomain.Size != len(next) {
+		t.Fatalf("%d cosets for a next layer of %d values", cosets.Len(), len(next))
+	}
+
+	fInv := DefaultField.NewFieldElement(big.NewInt(FRIFoldingFactor)).Inverse()
+	for _, j := range []int{0, 1, 77, cosets.Len() - 1} {
+		folded := Zero
+		betaI := One
+		for i := 0; i < FRIFoldingFactor; i++ {
+			part := Zero
+			for k, value := range cosets.Row(j) {
+				x := domain.Element(j + k*cosets.Len())
+				xi := x.Exp(DefaultField.NewFieldElement(big.NewInt(int64(i))))
+				part = part.Add(value.Mul(xi.Inverse()))
+			}
+			folded = folded.Add(part.Mul(fInv).Mul(betaI))
+			betaI = betaI.Mul(beta)
+		}
+		if !folded.IsEqual(next[j]) {
+			t.Errorf("coset %d folds to %s, next layer has %s", j, folded.Value, next[j].Value)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+)
+
+// Hasher is the hash function used for Merkle commitments and the Fiat-Shamir
+// transcript of a proof. Its name is recorded in the proof header so that a
+// verifier can select the same function.
+type Hasher interface {
+	Name() string
+	Hash(data []byte) Digest
+}
+
+type SHA256Hasher struct{}
+
+func (SHA256Hasher) Name() string { return "sha256" }
+
+func (SHA256Hasher) Hash(data []byte) Digest { return sha256.Sum256(data) }
+
+// SHA3Hasher is FIPS 202 SHA3-256. On-chain verifiers on Ethereum-like
+// chains only have Keccak-256, which pads differently; use KeccakHasher for
+// proofs they check.
+type SHA3Hasher struct{}
+
+func (SHA3Hasher) Name() string { return "sha3-256" }
+
+func (SHA3Hasher) Hash(data []byte) Digest { return sha3.Sum256(data) }
+
+// KeccakHasher is the original Keccak-256, as exposed by the EVM's KECCAK256
+// opcode.
+type KeccakHasher struct{}
+
+func (KeccakHasher) Name() string { return "keccak-256" }
+
+func (KeccakHasher) Hash(data []byte) Digest { return keccak256(data) }
+
+type Blake2sHasher struct{}
+
+func (Blake2sHasher) Name() string { return "blake2s-256" }
+
+func (Blake2sHasher) Hash(data []byte) Digest { return blake2s256(data) }
+
+var hashers = map[string]Hasher{
+	SHA256Hasher{}.Name():   SHA256Hasher{},
+	SHA3Hasher{}.Name():     SHA3Hasher{},
+	KeccakHasher{}.Name():   KeccakHasher{},
+	Blake2sHasher{}.Name():  Blake2sHasher{},
+	PoseidonHasher{}.Name(): PoseidonHasher{},
+}
+
+// HasherByName returns the hasher recorded under name in a proof header.
+func HasherByName(name string) (Hasher, bool) {
+	h, ok := hashers[name]
+	return h, ok
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha3"
+	"encoding/hex"
+	"testing"
+)
+
+func patternBytes(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func checkDigest(t *testing.T, name string, got Digest, want string) {
+	t.Helper()
+	if got.String() != want {
+		t.Errorf("%s = %s, want %s", name, got, want)
+	}
+}
+
+// The "abc" vector is from RFC 7693 appendix B. The 64- and 128-byte inputs
+// fill whole blocks, where the last block must be compressed with the final
+// flag rather than followed by an empty one.
+func TestBlake2sVectors(t *testing.T) {
+	vectors := []struct {
+		data   []byte
+		digest string
+	}{
+		{nil, "69217a3079908094e11121d042354a7c1f55b6482ca1a51e1b250dfd1ed0eef9"},
+		{[]byte("abc"), "508c5e8c327c14e2e1a72ba34eeb452f37458b209ed63a294d999b4c86675982"},
+		{patternBytes(64), "56f34e8b96557e90c1f24b52d0c89d51086acf1b00f634cf1dde9233b8eaaa3e"},
+		{patternBytes(65), "1b53ee94aaf34e4b159d48de352c7f0661d0a40edff95a0b1639b4090e974472"},
+		{patternBytes(128), "1fa877de67259d19863a2a34bcc6962a2b25fcbf5cbecd7ede8f1fa36688a796"},
+		{patternBytes(129), "5bd169e67c82c2c2e98ef7008bdf261f2ddf30b1c00f9e7f275bb3e8a28dc9a2"},
+	}
+	for _, v := range vectors {
+		checkDigest(t, "BLAKE2s("+hex.EncodeToString(v.data)+")", Blake2sHasher{}.Hash(v.data), v.digest)
+	}
+}
+
+func TestKeccakVectors(t *testing.T) {
+	checkDigest(t, `Keccak256("")`, KeccakHasher{}.Hash(nil), "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470")
+	checkDigest(t, `Keccak256("abc")`, KeccakHasher{}.Hash([]byte("abc")), "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45")
+}
+
+// Keccak-256 and SHA3-256 share the sponge and differ only in the padding
+// byte, so the sponge with SHA3 padding must match crypto/sha3 on every
+// length, including those around the 136-byte rate.
+func TestKeccakSpongeMatchesSHA3(t *testing.T) {
+	for n := 0; n <= 3*136+1; n++ {
+		data := patternBytes(n)
+		if keccakSponge(data, 0x06) != sha3.Sum256(data) {
+			t.Fatalf("length %d differs from SHA3-256", n)
+		}
+	}
+}
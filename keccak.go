@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+var keccakRoundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808a, 0x8000000080008000,
+	0x000000000000808b, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008a, 0x0000000000000088, 0x0000000080008009, 0x000000008000000a,
+	0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// keccakRotations[x][y] is the rho rotation of lane (x, y).
+var keccakRotations = [5][5]int{
+	{0, 36, 3, 41, 18},
+	{1, 44, 10, 45, 2},
+	{62, 6, 43, 15, 61},
+	{28, 55, 25, 21, 56},
+	{27, 20, 39, 8, 14},
+}
+
+// keccakF1600 applies the Keccak-f[1600] permutation to the state, where
+// lane (x, y) is a[x+5*y].
+func keccakF1600(a *[25]uint64) {
+	for round := 0; round < 24; round++ {
+		var c, d [5]uint64
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d[x] = c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+		}
+		var b [25]uint64
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y]^d[x], keccakRotations[x][y])
+			}
+		}
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				a[x+5*y] = b[x+5*y] ^ (^b[(x+1)%5+5*y] & b[(x+2)%5+5*y])
+			}
+		}
+		a[0] ^= keccakRoundConstants[round]
+	}
+}
+
+// keccakSponge hashes data to 32 bytes at rate 136 with the given domain
+// padding byte: 0x01 for the original Keccak-256 and 0x06 for FIPS 202
+// SHA3-256, which is otherwise the same function.
+func keccakSponge(data []byte, pad byte) Digest {
+	const rate = 136
+	var a [25]uint64
+	absorb := func(block []byte) {
+		for i := 0; i < rate/8; i++ {
+			a[i] ^= binary.LittleEndian.Uint64(block[8*i:])
+		}
+		keccakF1600(&a)
+	}
+	for len(data) >= rate {
+		absorb(data[:rate])
+		data = data[rate:]
+	}
+	var block [rate]byte
+	copy(block[:], data)
+	block[len(data)] ^= pad
+	block[rate-1] ^= 0x80
+	absorb(block[:])
+
+	var digest Digest
+	for i := 0; i < len(digest)/8; i++ {
+		binary.LittleEndian.PutUint64(digest[8*i:], a[i])
+	}
+	return digest
+}
+
+// keccak256 is the Keccak-256 hash used by Ethereum, which predates the
+// FIPS 202 padding and so differs from SHA3-256 on every input.
+func keccak256(data []byte) Digest {
+	return keccakSponge(data, 0x01)
+}
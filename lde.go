@@ -0,0 +1,50 @@
+package main
+
+// LDEConfig chooses the evaluation domain of the low-degree extension: the
+// coset Offset·H, where H is the subgroup Blowup times the size of the trace
+// domain.
+type LDEConfig struct {
+	Blowup int
+	Offset FiniteFieldElement
+}
+
+// DefaultLDEConfig extends by 8 onto the coset of the field generator.
+func DefaultLDEConfig() LDEConfig {
+	return LDEConfig{Blowup: 8, Offset: generator}
+}
+
+// Domain returns the evaluation domain for a trace of traceSize rows. It
+// panics unless Blowup is a power of two between 2 and 64 and the coset is
+// disjoint from the trace domain.
+func (c LDEConfig) Domain(traceSize int) Domain {
+	if c.Blowup < 2 || c.Blowup > 64 || c.Blowup&(c.Blowup-1) != 0 {
+		panic("blowup must be a power of two between 2 and 64")
+	}
+	lde := NewCosetDomain(traceSize*c.Blowup, c.Offset)
+	// The trace subgroup lies inside H, so the coset avoids it exactly when
+	// the offset is not itself in H.
+	if lde.Contains(One) {
+		panic("LDE coset is not disjoint from the trace domain")
+	}
+	return lde
+}
+
+// LowDegreeExtend interpolates column over the subgroup of its length and
+// evaluates the interpolant on the coset offset·H, where H is blowup times
+// larger.
+func LowDegreeExtend(column []FiniteFieldElement, blowup int, offset FiniteFieldElement) []FiniteFieldElement {
+	poly := NewSubgroupDomain(len(column)).Interpolate(column)
+	return LowDegreeExtendPolynomial(poly, len(column), blowup, offset)
+}
+
+// LowDegreeExtendPolynomial is LowDegreeExtend for a column that has
+// already been interpolated to poly over a trace domain of traceSize rows.
+func LowDegreeExtendPolynomial(poly Polynomial, traceSize, blowup int, offset FiniteFieldElement) []FiniteFieldElement {
+	return LDEConfig{Blowup: blowup, Offset: offset}.Domain(traceSize).Evaluate(poly)
+}
+
+// Extend evaluates poly on the evaluation domain of c for a trace of
+// traceSize rows.
+func (c LDEConfig) Extend(poly Polynomial, traceSize int) []FiniteFieldElement {
+	return LowDegreeExtendPolynomial(poly, traceSize, c.Blowup, c.Offset)
+}
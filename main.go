@@ -5,30 +5,30 @@ import (
 )
 
 func main() {
-	x_values := Values()
-	x_values = x_values[:len(x_values)-1]
-	y_values := fibSequence()
-	poly := Interpolation(x_values, y_values)
-
-	ch := NewChannel()
-	domain := EvalDomain()
-	result := poly.EvaluateDomain(domain)
-	root := MerkleRoot(MerkleTree(result))
-	ch.Send(root.hash)
+	traceDomain := TraceDomain()
+	column := fibSequence(traceLength)
+	poly := traceDomain.InterpolatePrefix(column)
+	lde := DefaultLDEConfig()
+
+	ch := NewChannel(ProtocolID, SHA256Hasher{})
+	vc := MerkleCommitment{Hasher: ch.hasher, CapHeight: MerkleCapHeight}
+	traceVC := MerkleCommitment{Hasher: ch.hasher, CapHeight: MerkleCapHeight, Salted: true}
+	domain := lde.Domain(traceDomain.Size)
+	result := MemoryElementStorage(lde.Extend(poly, traceDomain.Size))
+	trace := CommitColumn(traceVC, result)
+	ch.AbsorbCommitment("trace_root", trace.Commitment())
 
 	constraint1 := FirstConstraint(poly)
 	constraint2 := SecondConstraint(poly)
 	constraint3 := ThirdConstraint(poly)
 	cp := CompositionPolynomial(ch, constraint1, constraint2, constraint3)
-	result2 := cp.EvaluateDomain(domain)
-	root2 := MerkleRoot(MerkleTree(result2))
-	ch.Send(root2.hash)
-
-	cpeval := cp.EvaluateDomain(domain)
+	result2 := MemoryElementStorage(domain.Evaluate(cp))
+	root2 := CommitFRILayer(vc, result2)
+	ch.AbsorbCommitment("composition_root", root2.Commitment())
 
-	_, _, frilayers, frimerkles := FriCommit(cp, domain, cpeval, ch, MerkleTree(cpeval))
+	_, _, frilayers, fricommitments := FriCommit(cp, domain, result2, ch, vc, root2)
 
-	DecommitFRI(ch, poly, frilayers, frimerkles)
+	DecommitFRI(ch, lde, result, trace, frilayers, fricommitments)
 
 	fmt.Println("proof", ch.proof)
 }
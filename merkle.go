@@ -1,96 +1,577 @@
 package main
 
 import (
-	"crypto/sha256"
-	"fmt"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"runtime"
+	"sort"
+	"sync"
 )
 
-type Node struct {
-	hash  string
-	Left  *Node
-	Right *Node
+// Leaf, internal node, padding and commitment hashes are domain separated so
+// that no hash can be reinterpreted as one of another kind.
+const (
+	leafPrefix       byte = 0x00
+	nodePrefix       byte = 0x01
+	paddingPrefix    byte = 0x02
+	commitmentPrefix byte = 0x03
+	saltedLeafPrefix byte = 0x04
+)
+
+// SaltSize is the length in bytes of the random salt of a salted leaf.
+const SaltSize = 32
+
+type Digest [32]byte
+
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// elementHasher is implemented by algebraic hashers. Their trees hash rows
+// and digests as field elements rather than bytes, with the prefix as a
+// domain tag, so that a Merkle path can be checked inside an AIR.
+type elementHasher interface {
+	hashElements(prefix byte, elements []FiniteFieldElement) Digest
+	digestElements(d Digest) []FiniteFieldElement
 }
 
-func NewNode(data string) Node {
-	hash := sha256.Sum256([]byte(data))
-	return Node{
-		hash: fmt.Sprintf("%x", hash[:]),
+// hashLeaf hashes a row, prefixed by its salt if the tree is salted. An empty
+// salt means an unsalted leaf.
+func hashLeaf(hasher Hasher, salt []byte, row ...FiniteFieldElement) Digest {
+	if eh, ok := hasher.(elementHasher); ok {
+		if len(salt) > 0 {
+			return eh.hashElements(saltedLeafPrefix, append(eh.digestElements(Digest(salt)), row...))
+		}
+		return eh.hashElements(leafPrefix, row)
+	}
+	data := []byte{leafPrefix}
+	if len(salt) > 0 {
+		data = append([]byte{saltedLeafPrefix}, salt...)
 	}
+	for _, value := range row {
+		data = append(data, value.Bytes()...)
+	}
+	return hasher.Hash(data)
 }
-func MerkleTree(leavesField []FiniteFieldElement) [][]Node {
-	t := len(leavesField)
-	leaves := make([]string, t)
 
-	for i := 0; i < t; i++ {
-		leaves[i] = leavesField[i].Value.String()
+func hashNode(hasher Hasher, left, right Digest) Digest {
+	if eh, ok := hasher.(elementHasher); ok {
+		return eh.hashElements(nodePrefix, append(eh.digestElements(left), eh.digestElements(right)...))
 	}
+	data := make([]byte, 0, 1+2*len(left))
+	data = append(data, nodePrefix)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return hasher.Hash(data)
+}
 
-	var tree [][]Node
-	level := []Node{}
+// hashPadding is the digest of the empty leaves that fill a tree up to a
+// power of two. It cannot collide with the hash of any real leaf.
+func hashPadding(hasher Hasher) Digest {
+	if eh, ok := hasher.(elementHasher); ok {
+		return eh.hashElements(paddingPrefix, nil)
+	}
+	return hasher.Hash([]byte{paddingPrefix})
+}
 
-	if len(leaves)%2 != 0 {
-		leaves = append(leaves, leaves[len(leaves)-1])
+// hashCommitment binds the number of leaves to the cap nodes, so trees of
+// different sizes never share a commitment.
+func hashCommitment(hasher Hasher, leafCount int, merkleCap []Digest) Digest {
+	if eh, ok := hasher.(elementHasher); ok {
+		elements := []FiniteFieldElement{DefaultField.NewFieldElement(big.NewInt(int64(leafCount)))}
+		for _, node := range merkleCap {
+			elements = append(elements, eh.digestElements(node)...)
+		}
+		return eh.hashElements(commitmentPrefix, elements)
 	}
-	for _, leaf := range leaves {
-		level = append(level, NewNode(leaf))
+	data := []byte{commitmentPrefix}
+	data = binary.BigEndian.AppendUint64(data, uint64(leafCount))
+	for _, node := range merkleCap {
+		data = append(data, node[:]...)
 	}
-	tree = append(tree, level)
+	return hasher.Hash(data)
+}
 
-	for len(level) > 1 {
-		var nextLevel []Node
-		for i := 0; i < len(level); i += 2 {
-			var left, right Node
-			left = level[i]
-			if i+1 < len(level) {
-				right = level[i+1]
-			} else {
-				right = left
-			}
+// merkleDepth returns the number of levels above the leaves in a tree of
+// leafCount leaves padded to a power of two.
+func merkleDepth(leafCount int) int {
+	depth := 0
+	for 1<<depth < leafCount {
+		depth++
+	}
+	return depth
+}
 
-			combinedHash := NewNode(left.hash + right.hash)
-			combinedHash.Left = &left
-			combinedHash.Right = &right
-			nextLevel = append(nextLevel, combinedHash)
-		}
-		tree = append(tree, nextLevel)
-		level = nextLevel
+// MerklePath authenticates the leaf at Index of a tree with LeafCount leaves.
+// Siblings run from the leaf level upwards. Salt is the leaf's salt, empty
+// for unsalted trees.
+type MerklePath struct {
+	Index     int
+	Siblings  []Digest
+	LeafCount int
+	Salt      []byte
+}
+
+// MerkleMultiProof authenticates several leaves of a tree with LeafCount
+// leaves at once. Siblings holds, level by level and in ascending order, each
+// node that cannot be computed from the opened leaves exactly once, so paths
+// that share nodes share hashes. For salted trees Salts[i] is the salt of the
+// leaf at Indices[i]; only opened leaves have their salts revealed.
+type MerkleMultiProof struct {
+	Indices   []int
+	Siblings  []Digest
+	LeafCount int
+	Salts     [][]byte
+}
+
+// MerkleTree stores every level of the tree in one flat digest storage,
+// leaves first and the top node last, and finds nodes by index arithmetic.
+// The leaf level is padded to a power of two with hashPadding.
+type MerkleTree struct {
+	hasher  Hasher
+	rows    RowStorage
+	salts   [][]byte
+	nodes   DigestStorage
+	offsets []int
+	pruned  int
+}
+
+// merkleOffsets returns the start of every level in the flat node storage of
+// a tree with leafCount leaves, followed by the total number of nodes.
+func merkleOffsets(leafCount int) []int {
+	var offsets []int
+	offset := 0
+	for size := 1 << merkleDepth(leafCount); size >= 1; size /= 2 {
+		offsets = append(offsets, offset)
+		offset += size
 	}
+	return append(offsets, offset)
+}
 
-	return tree
+// MerkleNodeCount returns the size of the node storage needed by a tree with
+// leafCount leaves.
+func MerkleNodeCount(leafCount int) int {
+	offsets := merkleOffsets(leafCount)
+	return offsets[len(offsets)-1]
 }
-func MerkleRoot(MerkleTree [][]Node) Node {
-	t := len(MerkleTree)
-	return MerkleTree[t-1][0]
+
+func NewMerkleTree(leavesField []FiniteFieldElement, hasher Hasher) *MerkleTree {
+	rows := make([][]FiniteFieldElement, len(leavesField))
+	for i := range leavesField {
+		rows[i] = leavesField[i : i+1]
+	}
+	return NewRowMerkleTree(rows, hasher)
 }
-func MerkleProof(merkleTree [][]Node, index int) []string {
-	var proof []string
-	t := len(merkleTree)
-	for i := 0; i < t-1; i++ {
-		if index >= len(merkleTree[i]) {
-			break
+
+// NewRowMerkleTree commits to a vector of rows, each hashed as one leaf, so
+// that a multi-column trace or a group of values opened together has one root.
+// The rows are kept, not copied, to answer openings.
+func NewRowMerkleTree(rows [][]FiniteFieldElement, hasher Hasher) *MerkleTree {
+	return NewRowMerkleTreeWorkers(rows, hasher, runtime.GOMAXPROCS(0))
+}
+
+// NewRowMerkleTreeWorkers is NewRowMerkleTree hashing each level with up to
+// workers goroutines. Every node depends only on its children, so the tree is
+// identical for any worker count.
+func NewRowMerkleTreeWorkers(rows [][]FiniteFieldElement, hasher Hasher, workers int) *MerkleTree {
+	nodes := make(MemoryDigestStorage, MerkleNodeCount(len(rows)))
+	return NewStoredMerkleTree(MemoryRows(rows), nodes, hasher, workers)
+}
+
+// NewStoredMerkleTree builds a tree over rows into nodes, which must hold
+// MerkleNodeCount(rows.Len()) digests. With file-backed storages neither the
+// leaves nor the tree need to fit in memory, and openings read only the rows
+// and nodes they use.
+func NewStoredMerkleTree(rows RowStorage, nodes DigestStorage, hasher Hasher, workers int) *MerkleTree {
+	return buildMerkleTree(rows, nil, nodes, hasher, workers)
+}
+
+// NewSaltedRowMerkleTree is NewRowMerkleTree with a fresh random salt hashed
+// into every leaf, so the digests reveal nothing about rows that are never
+// opened, even when the rows themselves are easy to guess.
+func NewSaltedRowMerkleTree(rows [][]FiniteFieldElement, hasher Hasher) *MerkleTree {
+	nodes := make(MemoryDigestStorage, MerkleNodeCount(len(rows)))
+	return NewSaltedStoredMerkleTree(MemoryRows(rows), nodes, hasher, runtime.GOMAXPROCS(0))
+}
+
+// NewSaltedStoredMerkleTree is NewStoredMerkleTree with salted leaves. The
+// salts are kept in memory.
+func NewSaltedStoredMerkleTree(rows RowStorage, nodes DigestStorage, hasher Hasher, workers int) *MerkleTree {
+	buf := make([]byte, rows.Len()*SaltSize)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	salts := make([][]byte, rows.Len())
+	for i := range salts {
+		salts[i] = buf[i*SaltSize : (i+1)*SaltSize]
+	}
+	return buildMerkleTree(rows, salts, nodes, hasher, workers)
+}
+
+func buildMerkleTree(rows RowStorage, salts [][]byte, nodes DigestStorage, hasher Hasher, workers int) *MerkleTree {
+	if rows.Len() == 0 {
+		panic("cannot build a Merkle tree without leaves")
+	}
+	t := &MerkleTree{hasher: hasher, rows: rows, salts: salts, nodes: nodes, offsets: merkleOffsets(rows.Len())}
+	if nodes.Len() != MerkleNodeCount(rows.Len()) {
+		panic("Merkle node storage has the wrong size")
+	}
+
+	parallelFor(t.levelSize(0), workers, func(i int) {
+		nodes.Set(i, t.leaf(i))
+	})
+	for level := 1; level < t.Height(); level++ {
+		below, out := t.offsets[level-1], t.offsets[level]
+		parallelFor(t.levelSize(level), workers, func(i int) {
+			nodes.Set(out+i, hashNode(hasher, nodes.Get(below+2*i), nodes.Get(below+2*i+1)))
+		})
+	}
+	return t
+}
+
+// minParallelChunk keeps small levels, where goroutine overhead would
+// dominate, on the calling goroutine.
+const minParallelChunk = 256
+
+// parallelFor calls f for every i in [0, n), splitting the range into
+// contiguous chunks handled by up to workers goroutines.
+func parallelFor(n, workers int, f func(i int)) {
+	workers = min(workers, n/minParallelChunk)
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
 		}
-		if index%2 == 0 {
-			if index+1 < len(merkleTree[i]) {
-				proof = append(proof, merkleTree[i][index+1].hash)
+		return
+	}
+	var wg sync.WaitGroup
+	chunk := (n + workers - 1) / workers
+	for lo := 0; lo < n; lo += chunk {
+		hi := min(lo+chunk, n)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := lo; i < hi; i++ {
+				f(i)
 			}
-		} else {
-			proof = append(proof, merkleTree[i][index-1].hash)
+		}()
+	}
+	wg.Wait()
+}
+
+// Height returns the number of levels, counting the leaves and the top node.
+func (t *MerkleTree) Height() int {
+	return len(t.offsets) - 1
+}
+
+func (t *MerkleTree) LeafCount() int {
+	return t.rows.Len()
+}
+
+func (t *MerkleTree) levelSize(level int) int {
+	return t.offsets[level+1] - t.offsets[level]
+}
+
+func (t *MerkleTree) leaf(i int) Digest {
+	if i >= t.rows.Len() {
+		return hashPadding(t.hasher)
+	}
+	return hashLeaf(t.hasher, t.salt(i), t.rows.Row(i)...)
+}
+
+func (t *MerkleTree) salt(i int) []byte {
+	if t.salts == nil {
+		return nil
+	}
+	return t.salts[i]
+}
+
+// node returns the digest at index i of level, rehashing it from the rows
+// if its level has been pruned.
+func (t *MerkleTree) node(level, i int) Digest {
+	if level >= t.pruned {
+		return t.nodes.Get(t.offsets[level] - t.offsets[t.pruned] + i)
+	}
+	if level == 0 {
+		return t.leaf(i)
+	}
+	return hashNode(t.hasher, t.node(level-1, 2*i), t.node(level-1, 2*i+1))
+}
+
+// Root returns the commitment to the whole tree, which binds the top node
+// and the number of leaves.
+func (t *MerkleTree) Root() Digest {
+	return t.Commitment(0)
+}
+
+// capLevel returns the level holding the cap of the given height, the level
+// capHeight below the top node, clamped to the leaves.
+func (t *MerkleTree) capLevel(capHeight int) int {
+	return max(t.Height()-1-capHeight, 0)
+}
+
+// Cap returns the Merkle cap of the given height: the 2^capHeight nodes
+// capHeight levels below the top. Committing to the cap instead of the top
+// node shortens every authentication path by capHeight hashes.
+func (t *MerkleTree) Cap(capHeight int) []Digest {
+	level := t.capLevel(capHeight)
+	merkleCap := make([]Digest, t.levelSize(level))
+	for i := range merkleCap {
+		merkleCap[i] = t.node(level, i)
+	}
+	return merkleCap
+}
+
+// Commitment returns the digest committing to the cap of the given height
+// and to the number of leaves. A verifier checks revealed cap nodes against
+// it with VerifyCap.
+func (t *MerkleTree) Commitment(capHeight int) Digest {
+	return hashCommitment(t.hasher, t.LeafCount(), t.Cap(capHeight))
+}
+
+// Prune drops the lowest levels of the tree once it has been committed to,
+// keeping the rows. The remaining levels stay in the same storage, so a
+// file-backed tree keeps reading them from its file. Openings then rehash
+// the pruned part of each path from the rows, which costs 2^levels leaf
+// hashes per path.
+func (t *MerkleTree) Prune(levels int) {
+	levels = min(levels, t.Height()-1)
+	if levels <= t.pruned {
+		return
+	}
+	t.nodes = t.nodes.Slice(t.offsets[levels] - t.offsets[t.pruned])
+	t.pruned = levels
+}
+
+func (t *MerkleTree) Proof(index int) MerklePath {
+	return t.CappedProof(index, 0)
+}
+
+// CappedProof returns the authentication path of index up to the cap of the
+// given height.
+func (t *MerkleTree) CappedProof(index, capHeight int) MerklePath {
+	if index < 0 || index >= t.LeafCount() {
+		panic("Merkle leaf index out of range")
+	}
+	path := MerklePath{Index: index, LeafCount: t.LeafCount(), Salt: t.salt(index)}
+	for level := 0; level < t.capLevel(capHeight); level++ {
+		path.Siblings = append(path.Siblings, t.node(level, index^1))
+		index /= 2
+	}
+	return path
+}
+
+// Open returns the row committed at index together with its path.
+func (t *MerkleTree) Open(index int) ([]FiniteFieldElement, MerklePath) {
+	return t.rows.Row(index), t.Proof(index)
+}
+
+// RowsFromColumns transposes equal-length columns into rows for
+// NewRowMerkleTree.
+func RowsFromColumns(columns ...[]FiniteFieldElement) [][]FiniteFieldElement {
+	if len(columns) == 0 {
+		return nil
+	}
+	rows := make([][]FiniteFieldElement, len(columns[0]))
+	for i := range rows {
+		rows[i] = make([]FiniteFieldElement, len(columns))
+		for j, column := range columns {
+			if len(column) != len(rows) {
+				panic("columns have different lengths")
+			}
+			rows[i][j] = column[i]
 		}
-		index = index / 2
 	}
-	return proof
+	return rows
 }
-func VerifyMerkleProof(proof []string, item string, root string, index int) bool {
-	currentHash := NewNode(item).hash
-	for _, proofElement := range proof {
-		var combined string
+
+// VerifyCap checks revealed cap nodes against a commitment for a tree of
+// leafCount leaves. The cap must have a power-of-two size no larger than the
+// padded leaf level.
+func VerifyCap(merkleCap []Digest, leafCount int, commitment Digest, hasher Hasher) bool {
+	size := len(merkleCap)
+	if leafCount <= 0 || size == 0 || size&(size-1) != 0 || size > 1<<merkleDepth(leafCount) {
+		return false
+	}
+	return hashCommitment(hasher, leafCount, merkleCap) == commitment
+}
+
+func VerifyMerkleProof(path MerklePath, item FiniteFieldElement, root Digest, hasher Hasher) bool {
+	return VerifyRowMerkleProof(path, []FiniteFieldElement{item}, root, hasher)
+}
+
+func VerifyRowMerkleProof(path MerklePath, row []FiniteFieldElement, root Digest, hasher Hasher) bool {
+	top, ok := merklePathTop(path, row, 1, hasher)
+	return ok && hashCommitment(hasher, path.LeafCount, []Digest{top}) == root
+}
+
+// VerifyCappedRowProof checks a path produced by CappedProof against cap nodes
+// that have already been checked with VerifyCap.
+func VerifyCappedRowProof(path MerklePath, row []FiniteFieldElement, merkleCap []Digest, hasher Hasher) bool {
+	top, ok := merklePathTop(path, row, len(merkleCap), hasher)
+	return ok && top == merkleCap[path.Index>>len(path.Siblings)]
+}
+
+// merklePathTop hashes row up along path to the level with capSize nodes.
+// It rejects any path whose index or length does not match its leaf count.
+func merklePathTop(path MerklePath, row []FiniteFieldElement, capSize int, hasher Hasher) (Digest, bool) {
+	depth := merkleDepth(path.LeafCount)
+	if path.LeafCount <= 0 || path.Index < 0 || path.Index >= path.LeafCount {
+		return Digest{}, false
+	}
+	if len(path.Siblings) > depth || 1<<(depth-len(path.Siblings)) != capSize {
+		return Digest{}, false
+	}
+	if len(path.Salt) != 0 && len(path.Salt) != SaltSize {
+		return Digest{}, false
+	}
+	index := path.Index
+	currentHash := hashLeaf(hasher, path.Salt, row...)
+	for _, proofElement := range path.Siblings {
 		if index%2 == 0 {
-			combined = currentHash + proofElement
+			currentHash = hashNode(hasher, currentHash, proofElement)
 		} else {
-			combined = proofElement + currentHash
+			currentHash = hashNode(hasher, proofElement, currentHash)
 		}
-		currentHash = NewNode(combined).hash
 		index /= 2
 	}
-	return fmt.Sprintf("%x", []byte(currentHash)) == fmt.Sprintf("%x", []byte(root))
+	return currentHash, true
+}
+
+func uniqueSorted(indices []int) []int {
+	sorted := append([]int{}, indices...)
+	sort.Ints(sorted)
+	unique := sorted[:0]
+	for i, idx := range sorted {
+		if i == 0 || idx != sorted[i-1] {
+			unique = append(unique, idx)
+		}
+	}
+	return unique
+}
+
+func (t *MerkleTree) OpenMany(indices []int) MerkleMultiProof {
+	return t.CappedOpenMany(indices, 0)
+}
+
+// CappedOpenMany is OpenMany with every path stopping at the cap of the
+// given height.
+func (t *MerkleTree) CappedOpenMany(indices []int, capHeight int) MerkleMultiProof {
+	proof := MerkleMultiProof{Indices: indices, LeafCount: t.LeafCount()}
+	known := uniqueSorted(indices)
+	for _, idx := range known {
+		if idx < 0 || idx >= t.LeafCount() {
+			panic("Merkle leaf index out of range")
+		}
+	}
+	if t.salts != nil {
+		for _, idx := range indices {
+			proof.Salts = append(proof.Salts, t.salts[idx])
+		}
+	}
+	for level := 0; level < t.capLevel(capHeight); level++ {
+		var parents []int
+		for i := 0; i < len(known); i++ {
+			idx := known[i]
+			if i+1 < len(known) && known[i+1] == idx^1 {
+				i++
+			} else {
+				proof.Siblings = append(proof.Siblings, t.node(level, idx^1))
+			}
+			parents = append(parents, idx/2)
+		}
+		known = parents
+	}
+	return proof
+}
+
+// VerifyMany checks a multi-proof produced by OpenMany, where items[i] is
+// the leaf at proof.Indices[i].
+func VerifyMany(proof MerkleMultiProof, items []FiniteFieldElement, root Digest, hasher Hasher) bool {
+	rows := make([][]FiniteFieldElement, len(items))
+	for i := range items {
+		rows[i] = items[i : i+1]
+	}
+	return VerifyManyRows(proof, rows, root, hasher)
+}
+
+func VerifyManyRows(proof MerkleMultiProof, rows [][]FiniteFieldElement, root Digest, hasher Hasher) bool {
+	top, ok := merkleMultiProofTop(proof, rows, 1, hasher)
+	return ok && hashCommitment(hasher, proof.LeafCount, []Digest{top[0]}) == root
+}
+
+// VerifyCappedManyRows checks a multi-proof produced by CappedOpenMany against
+// cap nodes that have already been checked with VerifyCap.
+func VerifyCappedManyRows(proof MerkleMultiProof, rows [][]FiniteFieldElement, merkleCap []Digest, hasher Hasher) bool {
+	top, ok := merkleMultiProofTop(proof, rows, len(merkleCap), hasher)
+	if !ok {
+		return false
+	}
+	for idx, node := range top {
+		if node != merkleCap[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// merkleMultiProofTop hashes the opened rows up to the level with capSize
+// nodes and returns the nodes of that level it reached, by index. It rejects
+// proofs with missing or surplus siblings.
+func merkleMultiProofTop(proof MerkleMultiProof, rows [][]FiniteFieldElement, capSize int, hasher Hasher) (map[int]Digest, bool) {
+	if len(proof.Indices) != len(rows) || len(rows) == 0 || proof.LeafCount <= 0 {
+		return nil, false
+	}
+	size := 1 << merkleDepth(proof.LeafCount)
+	if capSize <= 0 || capSize&(capSize-1) != 0 || capSize > size {
+		return nil, false
+	}
+	if len(proof.Salts) != 0 && len(proof.Salts) != len(rows) {
+		return nil, false
+	}
+	nodes := map[int]Digest{}
+	for i, idx := range proof.Indices {
+		if idx < 0 || idx >= proof.LeafCount {
+			return nil, false
+		}
+		var salt []byte
+		if len(proof.Salts) != 0 {
+			if salt = proof.Salts[i]; len(salt) != SaltSize {
+				return nil, false
+			}
+		}
+		leaf := hashLeaf(hasher, salt, rows[i]...)
+		if existing, ok := nodes[idx]; ok && existing != leaf {
+			return nil, false
+		}
+		nodes[idx] = leaf
+	}
+	siblings := proof.Siblings
+	known := uniqueSorted(proof.Indices)
+	for ; size > capSize; size /= 2 {
+		next := map[int]Digest{}
+		var parents []int
+		for i := 0; i < len(known); i++ {
+			idx := known[i]
+			var sibling Digest
+			if i+1 < len(known) && known[i+1] == idx^1 {
+				sibling = nodes[idx^1]
+				i++
+			} else {
+				if len(siblings) == 0 {
+					return nil, false
+				}
+				sibling, siblings = siblings[0], siblings[1:]
+			}
+			if idx%2 == 0 {
+				next[idx/2] = hashNode(hasher, nodes[idx], sibling)
+			} else {
+				next[idx/2] = hashNode(hasher, sibling, nodes[idx])
+			}
+			parents = append(parents, idx/2)
+		}
+		nodes, known = next, parents
+	}
+	return nodes, len(siblings) == 0
 }
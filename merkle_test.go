@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"runtime"
+	"testing"
+)
+
+func merkleTestRows(n int) [][]FiniteFieldElement {
+	rows := make([][]FiniteFieldElement, n)
+	for i := range rows {
+		rows[i] = []FiniteFieldElement{DefaultField.NewFieldElement(big.NewInt(int64(i)*7919 + 1))}
+	}
+	return rows
+}
+
+// The leaf count is not a power of two and large enough that every level
+// near the leaves is split across several workers.
+func TestParallelMerkleTreeMatchesSequential(t *testing.T) {
+	rows := merkleTestRows(5000)
+	sequential := NewRowMerkleTreeWorkers(rows, SHA256Hasher{}, 1)
+	parallel := NewRowMerkleTreeWorkers(rows, SHA256Hasher{}, 8)
+	if sequential.Root() != parallel.Root() {
+		t.Fatal("roots differ")
+	}
+	for i := 0; i < sequential.nodes.Len(); i++ {
+		if sequential.nodes.Get(i) != parallel.nodes.Get(i) {
+			t.Fatalf("node %d differs", i)
+		}
+	}
+}
+
+// Each case edits the path of the last leaf of a 13-leaf tree, padded to 16,
+// up to its cap of height 1.
+func TestVerifyCappedRowProof(t *testing.T) {
+	rows := merkleTestRows(13)
+	trees := map[bool]*MerkleTree{
+		false: NewRowMerkleTree(rows, SHA256Hasher{}),
+		true:  NewSaltedRowMerkleTree(rows, SHA256Hasher{}),
+	}
+	tests := []struct {
+		name   string
+		salted bool
+		edit   func(path *MerklePath, merkleCap *[]Digest)
+		want   bool
+	}{
+		{"unsalted", false, func(*MerklePath, *[]Digest) {}, true},
+		{"salted", true, func(*MerklePath, *[]Digest) {}, true},
+		{"larger leaf count", false, func(path *MerklePath, _ *[]Digest) { path.LeafCount = 16 }, false},
+		{"leaf count excluding the index", false, func(path *MerklePath, _ *[]Digest) { path.LeafCount = 12 }, false},
+		{"leaf count of a deeper tree", false, func(path *MerklePath, _ *[]Digest) { path.LeafCount = 40 }, false},
+		{"path one short", false, func(path *MerklePath, _ *[]Digest) { path.Siblings = path.Siblings[1:] }, false},
+		{"path one long", false, func(path *MerklePath, _ *[]Digest) {
+			path.Siblings = append(path.Siblings, Digest{})
+		}, false},
+		{"cap one short", false, func(_ *MerklePath, merkleCap *[]Digest) { *merkleCap = (*merkleCap)[:1] }, false},
+		{"short salt", true, func(path *MerklePath, _ *[]Digest) { path.Salt = path.Salt[1:] }, false},
+		{"salted opening checked unsalted", true, func(path *MerklePath, _ *[]Digest) { path.Salt = nil }, false},
+		{"unsalted opening given a salt", false, func(path *MerklePath, _ *[]Digest) {
+			path.Salt = make([]byte, SaltSize)
+		}, false},
+	}
+	for _, tt := range tests {
+		tree := trees[tt.salted]
+		path, merkleCap := tree.CappedProof(12, 1), tree.Cap(1)
+		tt.edit(&path, &merkleCap)
+		got := VerifyCap(merkleCap, path.LeafCount, tree.Commitment(1), SHA256Hasher{}) &&
+			VerifyCappedRowProof(path, rows[12], merkleCap, SHA256Hasher{})
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Each case edits an opening of leaves 0 and 12, the last, of a 13-leaf
+// column committed to with cap height 1, and may check it with a verifier
+// that disagrees about salting.
+func TestMerkleCommitmentVerify(t *testing.T) {
+	column := make(MemoryElementStorage, 13)
+	for i, row := range merkleTestRows(13) {
+		column[i] = row[0]
+	}
+	rows := [][]FiniteFieldElement{{column[0]}, {column[12]}}
+	tests := []struct {
+		name                   string
+		salted, verifierSalted bool
+		edit                   func(opening *VectorOpening)
+		want                   bool
+	}{
+		{"unsalted", false, false, func(*VectorOpening) {}, true},
+		{"salted", true, true, func(*VectorOpening) {}, true},
+		{"salted checked unsalted", true, false, func(*VectorOpening) {}, false},
+		{"unsalted checked salted", false, true, func(*VectorOpening) {}, false},
+		{"larger leaf count", false, false, func(opening *VectorOpening) { opening.Proof.LeafCount = 16 }, false},
+		{"smaller leaf count", false, false, func(opening *VectorOpening) { opening.Proof.LeafCount = 12 }, false},
+		{"sibling missing", false, false, func(opening *VectorOpening) {
+			opening.Proof.Siblings = opening.Proof.Siblings[1:]
+		}, false},
+		{"short salt", true, true, func(opening *VectorOpening) {
+			opening.Proof.Salts[1] = opening.Proof.Salts[1][1:]
+		}, false},
+		{"salt missing", true, true, func(opening *VectorOpening) {
+			opening.Proof.Salts = opening.Proof.Salts[:1]
+		}, false},
+	}
+	for _, tt := range tests {
+		committed := CommitColumn(MerkleCommitment{Hasher: SHA256Hasher{}, CapHeight: 1, Salted: tt.salted}, column)
+		opening := committed.Open([]int{0, 12})
+		tt.edit(&opening)
+		verifier := MerkleCommitment{Hasher: SHA256Hasher{}, CapHeight: 1, Salted: tt.verifierSalted}
+		if got := verifier.Verify(committed.Commitment(), opening, rows); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Each case edits a multi-proof of leaves 2, 3, 9 and again 9 of a 13-leaf
+// tree. Leaves 2 and 3 share a parent, so neither needs a sibling.
+func TestVerifyManyRows(t *testing.T) {
+	rows := merkleTestRows(13)
+	tree := NewRowMerkleTree(rows, SHA256Hasher{})
+	tests := []struct {
+		name string
+		edit func(proof *MerkleMultiProof, opened [][]FiniteFieldElement)
+		want bool
+	}{
+		{"valid", func(*MerkleMultiProof, [][]FiniteFieldElement) {}, true},
+		{"duplicate index with another row", func(_ *MerkleMultiProof, opened [][]FiniteFieldElement) {
+			opened[3] = rows[8]
+		}, false},
+		{"tampered sibling", func(proof *MerkleMultiProof, _ [][]FiniteFieldElement) {
+			proof.Siblings[1][0] ^= 1
+		}, false},
+		{"surplus sibling", func(proof *MerkleMultiProof, _ [][]FiniteFieldElement) {
+			proof.Siblings = append(proof.Siblings, Digest{})
+		}, false},
+		{"missing sibling", func(proof *MerkleMultiProof, _ [][]FiniteFieldElement) {
+			proof.Siblings = proof.Siblings[:len(proof.Siblings)-1]
+		}, false},
+		{"index in the padding", func(proof *MerkleMultiProof, _ [][]FiniteFieldElement) {
+			proof.Indices[2], proof.Indices[3] = 13, 13
+		}, false},
+		{"index past the padding", func(proof *MerkleMultiProof, _ [][]FiniteFieldElement) {
+			proof.Indices[0] = 18
+		}, false},
+	}
+	for _, tt := range tests {
+		indices := []int{2, 3, 9, 9}
+		proof := tree.OpenMany(indices)
+		// Leaf 8 and the nodes over leaves 0-1, 10-11, 4-7 and 12-15.
+		if len(proof.Siblings) != 5 {
+			t.Fatalf("proof has %d siblings, want 5", len(proof.Siblings))
+		}
+		opened := [][]FiniteFieldElement{rows[2], rows[3], rows[9], rows[9]}
+		tt.edit(&proof, opened)
+		if got := VerifyManyRows(proof, opened, tree.Root(), SHA256Hasher{}); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOpenManyRejectsIndexPastLeafCount(t *testing.T) {
+	tree := NewRowMerkleTree(merkleTestRows(13), SHA256Hasher{})
+	defer func() {
+		if recover() == nil {
+			t.Fatal("opening leaf 13 of 13 did not panic")
+		}
+	}()
+	tree.OpenMany([]int{2, 13})
+}
+
+// An opening only verifies under the cap height it was made with, except
+// that every height reaching past the top of a small tree means the same cap.
+func TestMerkleCommitmentChecksCapHeight(t *testing.T) {
+	column := make(MemoryElementStorage, 64)
+	for i := range column {
+		column[i] = DefaultField.NewFieldElement(big.NewInt(int64(i)))
+	}
+	for _, tc := range []struct {
+		leaves, opened, verified int
+		want                     bool
+	}{
+		{64, 2, 2, true},
+		{64, 2, 0, false},
+		{64, 0, 2, false},
+		{64, 2, 3, false},
+		{4, 2, 5, true},
+		{4, 1, 2, false},
+	} {
+		prover := MerkleCommitment{Hasher: SHA256Hasher{}, CapHeight: tc.opened}
+		verifier := MerkleCommitment{Hasher: SHA256Hasher{}, CapHeight: tc.verified}
+		committed := CommitColumn(prover, column[:tc.leaves])
+		rows := [][]FiniteFieldElement{{column[3]}}
+		if got := verifier.Verify(committed.Commitment(), committed.Open([]int{3}), rows); got != tc.want {
+			t.Errorf("%d leaves, cap height %d checked at %d: got %v", tc.leaves, tc.opened, tc.verified, got)
+		}
+	}
+}
+
+func BenchmarkMerkleTree(b *testing.B) {
+	workerCounts := []int{1}
+	if procs := runtime.GOMAXPROCS(0); procs > 1 {
+		workerCounts = append(workerCounts, procs)
+	}
+	for logSize := 10; logSize <= 24; logSize++ {
+		for _, workers := range workerCounts {
+			b.Run(fmt.Sprintf("leaves=2^%d/workers=%d", logSize, workers), func(b *testing.B) {
+				rows := merkleTestRows(1 << logSize)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					NewRowMerkleTreeWorkers(rows, SHA256Hasher{}, workers)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// TraceCell is the variable standing for the value of trace column Column at
+// Offset rows after the current one, so {0, 0} is the current row and {0, 1}
+// the next row of the first column.
+type TraceCell struct {
+	Column int
+	Offset int
+}
+
+type Term struct {
+	Coeff  FiniteFieldElement
+	Powers map[TraceCell]int
+}
+
+// MultiPolynomial is a polynomial over trace cells, the natural form of an
+// AIR transition constraint. Terms are keyed by their monomial so like terms
+// are always combined.
+type MultiPolynomial struct {
+	terms map[string]Term
+}
+
+func NewTraceCell(column, offset int) MultiPolynomial {
+	cell := TraceCell{Column: column, Offset: offset}
+	return newMultiPolynomial(Term{Coeff: One, Powers: map[TraceCell]int{cell: 1}})
+}
+
+func NewConstantMultiPolynomial(c FiniteFieldElement) MultiPolynomial {
+	return newMultiPolynomial(Term{Coeff: c, Powers: map[TraceCell]int{}})
+}
+
+func newMultiPolynomial(terms ...Term) MultiPolynomial {
+	result := MultiPolynomial{terms: map[string]Term{}}
+	for _, term := range terms {
+		result.addTerm(term)
+	}
+	return result
+}
+
+func monomialKey(powers map[TraceCell]int) string {
+	parts := make([]string, 0, len(powers))
+	for cell, e := range powers {
+		parts = append(parts, fmt.Sprintf("%d:%d^%d", cell.Column, cell.Offset, e))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (m *MultiPolynomial) addTerm(term Term) {
+	key := monomialKey(term.Powers)
+	if existing, ok := m.terms[key]; ok {
+		term.Coeff = term.Coeff.Add(existing.Coeff)
+	}
+	if term.Coeff.IsZero() {
+		delete(m.terms, key)
+		return
+	}
+	m.terms[key] = term
+}
+
+func (m MultiPolynomial) Terms() []Term {
+	keys := make([]string, 0, len(m.terms))
+	for key := range m.terms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	terms := make([]Term, len(keys))
+	for i, key := range keys {
+		terms[i] = m.terms[key]
+	}
+	return terms
+}
+
+func (m MultiPolynomial) Add(o MultiPolynomial) MultiPolynomial {
+	return newMultiPolynomial(append(m.Terms(), o.Terms()...)...)
+}
+
+func (m MultiPolynomial) Neg() MultiPolynomial {
+	return m.ScalarMul(One.Negate())
+}
+
+func (m MultiPolynomial) Sub(o MultiPolynomial) MultiPolynomial {
+	return m.Add(o.Neg())
+}
+
+func (m MultiPolynomial) ScalarMul(k FiniteFieldElement) MultiPolynomial {
+	var terms []Term
+	for _, term := range m.terms {
+		terms = append(terms, Term{Coeff: term.Coeff.Mul(k), Powers: term.Powers})
+	}
+	return newMultiPolynomial(terms...)
+}
+
+func (m MultiPolynomial) Mul(o MultiPolynomial) MultiPolynomial {
+	var terms []Term
+	for _, a := range m.terms {
+		for _, b := range o.terms {
+			powers := map[TraceCell]int{}
+			for cell, e := range a.Powers {
+				powers[cell] += e
+			}
+			for cell, e := range b.Powers {
+				powers[cell] += e
+			}
+			terms = append(terms, Term{Coeff: a.Coeff.Mul(b.Coeff), Powers: powers})
+		}
+	}
+	return newMultiPolynomial(terms...)
+}
+
+func (m MultiPolynomial) Exp(e int) MultiPolynomial {
+	acc := NewConstantMultiPolynomial(One)
+	for i := 0; i < e; i++ {
+		acc = acc.Mul(m)
+	}
+	return acc
+}
+
+// Degree returns the total degree, or -1 for the zero polynomial.
+func (m MultiPolynomial) Degree() int {
+	deg := -1
+	for _, term := range m.terms {
+		d := 0
+		for _, e := range term.Powers {
+			d += e
+		}
+		deg = max(deg, d)
+	}
+	return deg
+}
+
+func (m MultiPolynomial) Evaluate(cells map[TraceCell]FiniteFieldElement) FiniteFieldElement {
+	value := Zero
+	for _, term := range m.terms {
+		t := term.Coeff
+		for cell, e := range term.Powers {
+			x, ok := cells[cell]
+			if !ok {
+				panic(fmt.Sprintf("no value for trace cell %v", cell))
+			}
+			t = t.Mul(x.Exp(FiniteFieldElement{Value: big.NewInt(int64(e)), Field: DefaultField}))
+		}
+		value = value.Add(t)
+	}
+	return value
+}
+
+// Substitute replaces every cell {c, k} with the shifted trace polynomial
+// columns[c](g^k·X), where g generates the trace domain, and returns the
+// resulting univariate polynomial.
+func (m MultiPolynomial) Substitute(columns []Polynomial, g FiniteFieldElement) Polynomial {
+	shifted := map[TraceCell]Polynomial{}
+	result := Polynomial{}
+	for _, term := range m.Terms() {
+		t := newPolynomial([]FiniteFieldElement{term.Coeff})
+		for cell, e := range term.Powers {
+			p, ok := shifted[cell]
+			if !ok {
+				shift := g.Exp(FiniteFieldElement{Value: big.NewInt(int64(cell.Offset)), Field: DefaultField})
+				p = columns[cell.Column].ScaleArg(shift)
+				shifted[cell] = p
+			}
+			t = t.Mul(p.Exp(FiniteFieldElement{Value: big.NewInt(int64(e)), Field: DefaultField}))
+		}
+		result = result.Add(t)
+	}
+	return result
+}
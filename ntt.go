@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math/big"
+)
+
+// NTT evaluates the polynomial with the given coefficients at the powers
+// omega^0, ..., omega^(n-1), where n = len(coeffs) divides p - 1 = 3·2^30, so
+// is 2^a or 3·2^a, and omega is a primitive n-th root of unity.
+func NTT(coeffs []FiniteFieldElement, omega FiniteFieldElement) []FiniteFieldElement {
+	n := len(coeffs)
+	if !dividesGroupOrder(n) {
+		panic("NTT size must divide p - 1")
+	}
+	return ntt(coeffs, omega)
+}
+
+// InverseNTT recovers the coefficients of a polynomial from its evaluations
+// at the powers of omega.
+func InverseNTT(values []FiniteFieldElement, omega FiniteFieldElement) []FiniteFieldElement {
+	coeffs := NTT(values, omega.Inverse())
+	nInv := FiniteFieldElement{Value: big.NewInt(int64(len(values))), Field: DefaultField}.Inverse()
+	for i := range coeffs {
+		coeffs[i] = coeffs[i].Mul(nInv)
+	}
+	return coeffs
+}
+
+// ntt is a mixed-radix Cooley-Tukey transform. It splits coeffs into r
+// decimated subsequences, transforms each with omega^r and combines them
+// with r-point DFTs over the r-th roots of unity omega^(n/r).
+func ntt(coeffs []FiniteFieldElement, omega FiniteFieldElement) []FiniteFieldElement {
+	n := len(coeffs)
+	if n == 1 {
+		return []FiniteFieldElement{coeffs[0]}
+	}
+	r := 2
+	if n%2 != 0 {
+		r = 3
+	}
+	m := n / r
+	omegaR := omega.Exp(FiniteFieldElement{Value: big.NewInt(int64(r)), Field: DefaultField})
+	subEvals := make([][]FiniteFieldElement, r)
+	for j := range subEvals {
+		sub := make([]FiniteFieldElement, m)
+		for i := range sub {
+			sub[i] = coeffs[i*r+j]
+		}
+		subEvals[j] = ntt(sub, omegaR)
+	}
+	roots := make([]FiniteFieldElement, r)
+	roots[0] = One
+	zeta := omega.Exp(FiniteFieldElement{Value: big.NewInt(int64(m)), Field: DefaultField})
+	for j := 1; j < r; j++ {
+		roots[j] = roots[j-1].Mul(zeta)
+	}
+
+	result := make([]FiniteFieldElement, n)
+	twiddles := make([]FiniteFieldElement, r)
+	w := One
+	for k := 0; k < m; k++ {
+		wj := One
+		for j := 0; j < r; j++ {
+			twiddles[j] = wj.Mul(subEvals[j][k])
+			wj = wj.Mul(w)
+		}
+		for l := 0; l < r; l++ {
+			acc := Zero
+			for j := 0; j < r; j++ {
+				acc = acc.Add(twiddles[j].Mul(roots[(j*l)%r]))
+			}
+			result[k+l*m] = acc
+		}
+		w = w.Mul(omega)
+	}
+	return result
+}
+
+// dividesGroupOrder reports whether n divides p - 1, which is exactly when
+// the field has a primitive n-th root of unity.
+func dividesGroupOrder(n int) bool {
+	order := new(big.Int).Sub(DefaultFieldSize, big.NewInt(1))
+	return n > 0 && new(big.Int).Mod(order, big.NewInt(int64(n))).Sign() == 0
+}
+
+// SmoothDomainSize returns the smallest size of the form 2^k or 3·2^k that is
+// at least n. Both divide p - 1 = 3·2^30 for k small enough, and padding to
+// them wastes at most a third of the domain instead of up to a half.
+func SmoothDomainSize(n int) int {
+	size := 1
+	for size < n {
+		size *= 2
+	}
+	if size >= 4 && size/4*3 >= n {
+		size = size / 4 * 3
+	}
+	if !dividesGroupOrder(size) {
+		panic("no domain of that size divides p - 1")
+	}
+	return size
+}
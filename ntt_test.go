@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestNTTMatchesEvaluation(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 8, 12, 48} {
+		coeffs := make([]FiniteFieldElement, n)
+		for i := range coeffs {
+			coeffs[i] = DefaultField.NewFieldElement(big.NewInt(int64(i*i + 3)))
+		}
+		domain := NewSubgroupDomain(n)
+		got := NTT(coeffs, domain.Generator)
+		for i, x := range domain.Elements() {
+			if !got[i].IsEqual(NewPolyFromField(coeffs).Evaluate(x)) {
+				t.Fatalf("size %d: value %d differs", n, i)
+			}
+		}
+	}
+}
+
+// 9 is of the form 2^a·3^b but does not divide p - 1 = 3·2^30, so there is
+// no root of unity of that order.
+func TestNTTRejectsSizeNotDividingGroupOrder(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NTT of size 9 did not panic")
+		}
+	}()
+	NTT(make([]FiniteFieldElement, 9), One)
+}
+
+func TestSmoothDomainSize(t *testing.T) {
+	for n, want := range map[int]int{1: 1, 3: 3, 5: 6, 7: 8, 1023: 1024, 1025: 1536} {
+		if got := SmoothDomainSize(n); got != want {
+			t.Errorf("SmoothDomainSize(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestInterpolatePrefix(t *testing.T) {
+	domain := NewSubgroupDomain(16)
+	for _, n := range []int{1, 5, 15, 16} {
+		values := make([]FiniteFieldElement, n)
+		for i := range values {
+			values[i] = DefaultField.NewFieldElement(big.NewInt(int64(i*i*i + 11)))
+		}
+		poly := domain.InterpolatePrefix(values)
+		if poly.Degree() >= n {
+			t.Errorf("%d values: degree %d", n, poly.Degree())
+		}
+		for i, v := range values {
+			if !poly.Evaluate(domain.Element(i)).IsEqual(v) {
+				t.Errorf("%d values: value %d differs", n, i)
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"math/big"
+	"sort"
 )
 
 type Polynomial struct {
@@ -10,19 +11,29 @@ type Polynomial struct {
 }
 
 func NewPolyFromField(f []FiniteFieldElement) Polynomial {
-	var result Polynomial
-	result.coeffs = append(result.coeffs, f...)
-	return result
+	return newPolynomial(append([]FiniteFieldElement{}, f...))
+}
+
+// newPolynomial takes ownership of coeffs and trims trailing zeros, so that
+// every Polynomial is canonical: the zero polynomial has no coefficients and
+// any other polynomial ends in a non-zero leading coefficient.
+func newPolynomial(coeffs []FiniteFieldElement) Polynomial {
+	t := len(coeffs)
+	for t > 0 && coeffs[t-1].IsZero() {
+		t--
+	}
+	return Polynomial{coeffs: coeffs[:t]}
 }
 
 func (p Polynomial) Degree() int {
-	deg := -1
-	for i := range p.coeffs {
-		if !p.coeffs[i].IsEqual(Zero) {
-			deg = i
-		}
+	return len(p.coeffs) - 1
+}
+
+func (p Polynomial) Coeff(i int) FiniteFieldElement {
+	if i < 0 || i >= len(p.coeffs) {
+		return Zero
 	}
-	return deg
+	return p.coeffs[i]
 }
 
 func (p Polynomial) Neg() Polynomial {
@@ -30,7 +41,7 @@ func (p Polynomial) Neg() Polynomial {
 	for i, c := range p.coeffs {
 		negCoeffs[i] = c.Negate()
 	}
-	return Polynomial{coeffs: negCoeffs}
+	return newPolynomial(negCoeffs)
 }
 
 func (p Polynomial) Add(q Polynomial) Polynomial {
@@ -59,7 +70,7 @@ func (p Polynomial) Add(q Polynomial) Polynomial {
 		}
 		newCoeffs[i] = a.Add(b)
 	}
-	return Polynomial{coeffs: newCoeffs}
+	return newPolynomial(newCoeffs)
 }
 
 func (p Polynomial) Sub(q Polynomial) Polynomial {
@@ -67,8 +78,8 @@ func (p Polynomial) Sub(q Polynomial) Polynomial {
 }
 
 func (p Polynomial) Mul(q Polynomial) Polynomial {
-	if len(p.coeffs) == 0 || len(q.coeffs) == 0 {
-		return Polynomial{coeffs: []FiniteFieldElement{}}
+	if p.IsZero() || q.IsZero() {
+		return Polynomial{}
 	}
 	t := len(p.coeffs)
 	k := len(q.coeffs)
@@ -84,26 +95,25 @@ func (p Polynomial) Mul(q Polynomial) Polynomial {
 			buf[i+j] = buf[i+j].Add(p.coeffs[i].Mul(q.coeffs[j]))
 		}
 	}
-	return Polynomial{coeffs: buf}
+	return newPolynomial(buf)
 }
 func (p Polynomial) ScalarMul(k FiniteFieldElement) Polynomial {
 	if k.IsZero() {
-		return Polynomial{coeffs: []FiniteFieldElement{Zero}}
+		return Polynomial{}
 	}
 
-	var newCoeffs []FiniteFieldElement
-	for _, coeff := range p.coeffs {
-		newCoeffs = append(newCoeffs, coeff.Mul(k))
+	newCoeffs := make([]FiniteFieldElement, len(p.coeffs))
+	for i, coeff := range p.coeffs {
+		newCoeffs[i] = coeff.Mul(k)
 	}
-	return Polynomial{coeffs: newCoeffs}
+	return newPolynomial(newCoeffs)
 }
 
 func (p Polynomial) IsEqual(q Polynomial) bool {
-	if p.Degree() != q.Degree() {
+	if len(p.coeffs) != len(q.coeffs) {
 		return false
 	}
-	t := p.Degree() + 1
-	for i := 0; i < t; i++ {
+	for i := range p.coeffs {
 		if !p.coeffs[i].IsEqual(q.coeffs[i]) {
 			return false
 		}
@@ -112,39 +122,23 @@ func (p Polynomial) IsEqual(q Polynomial) bool {
 }
 
 func (p Polynomial) IsZero() bool {
-	t := len(p.coeffs)
-	for i := 0; i < t; i++ {
-		if !p.coeffs[i].IsZero() {
-			return false
-		}
-	}
-	return true
+	return len(p.coeffs) == 0
 }
 
 func (p Polynomial) LeadingCoeff() FiniteFieldElement {
-	t := p.Degree()
-	if t != -1 {
-		return p.coeffs[p.Degree()]
-	}
-	return Zero
+	return p.Coeff(p.Degree())
 }
 
+// Divide returns the quotient and remainder of numerator / denominator, with
+// deg(remainder) < deg(denominator). It panics if denominator is zero.
 func (numerator Polynomial) Divide(denominator Polynomial) (quotient, remainder Polynomial) {
-	if denominator.Degree() == -1 {
-		return Polynomial{}, Polynomial{}
+	if denominator.IsZero() {
+		panic("division by zero polynomial")
 	}
 	if numerator.Degree() < denominator.Degree() {
-		return Polynomial{coeffs: []FiniteFieldElement{Zero}}, numerator
-	}
-	remainder = Polynomial{
-		coeffs: make([]FiniteFieldElement, len(numerator.coeffs)),
-	}
-	for i := range numerator.coeffs {
-		remainder.coeffs[i] = FiniteFieldElement{
-			Value: new(big.Int).Set(numerator.coeffs[i].Value),
-			Field: numerator.coeffs[i].Field,
-		}
+		return Polynomial{}, numerator
 	}
+	remainder = numerator
 	quotientSize := numerator.Degree() - denominator.Degree() + 1
 	quotientCoeffs := make([]FiniteFieldElement, quotientSize)
 	for i := range quotientCoeffs {
@@ -173,7 +167,7 @@ func (numerator Polynomial) Divide(denominator Polynomial) (quotient, remainder
 		remainder = remainder.Sub(subtractee)
 	}
 
-	quotient = Polynomial{coeffs: quotientCoeffs}
+	quotient = newPolynomial(quotientCoeffs)
 	return quotient, remainder
 }
 
@@ -217,12 +211,23 @@ func (p Polynomial) EvaluateDomain(points []FiniteFieldElement) []FiniteFieldEle
 	}
 	return results
 }
-func (p Polynomial) Compose(q Polynomial) Polynomial {
-	result := Polynomial{coeffs: []FiniteFieldElement{Zero}}
+func (p Polynomial) ScaleArg(c FiniteFieldElement) Polynomial {
+	newCoeffs := make([]FiniteFieldElement, len(p.coeffs))
+	ci := One
 	for i, coeff := range p.coeffs {
-		term := q.Exp(FiniteFieldElement{Value: big.NewInt(int64(i)), Field: DefaultField})
-		term = term.Mul(Polynomial{coeffs: []FiniteFieldElement{coeff}})
-		result = result.Add(term)
+		newCoeffs[i] = coeff.Mul(ci)
+		ci = ci.Mul(c)
+	}
+	return newPolynomial(newCoeffs)
+}
+
+func (p Polynomial) Compose(q Polynomial) Polynomial {
+	if q.Degree() == 1 && q.coeffs[0].IsZero() {
+		return p.ScaleArg(q.coeffs[1])
+	}
+	result := Polynomial{}
+	for i := len(p.coeffs) - 1; i >= 0; i-- {
+		result = result.Mul(q).Add(newPolynomial([]FiniteFieldElement{p.coeffs[i]}))
 	}
 	return result
 }
@@ -234,10 +239,10 @@ func Interpolation(domain, values []FiniteFieldElement) Polynomial {
 	if len(domain) == 0 {
 		panic("cannot interpolate between zero points")
 	}
-	acc := Polynomial{coeffs: []FiniteFieldElement{}}
+	acc := Polynomial{}
 	t := len(domain)
 	for i := 0; i < t; i++ {
-		prod := Polynomial{coeffs: []FiniteFieldElement{values[i]}}
+		prod := newPolynomial([]FiniteFieldElement{values[i]})
 
 		for j := 0; j < t; j++ {
 			if j == i {
@@ -247,9 +252,76 @@ func Interpolation(domain, values []FiniteFieldElement) Polynomial {
 
 			denomInverse := domain[i].Sub(domain[j]).Inverse()
 
-			prod = prod.Mul(xMinusXj).Mul(Polynomial{coeffs: []FiniteFieldElement{denomInverse}})
+			prod = prod.Mul(xMinusXj).ScalarMul(denomInverse)
 		}
 		acc = acc.Add(prod)
 	}
 	return acc
 }
+
+func (p Polynomial) Monic() Polynomial {
+	if p.IsZero() {
+		return p
+	}
+	return p.ScalarMul(p.LeadingCoeff().Inverse())
+}
+
+func (p Polynomial) Gcd(q Polynomial) Polynomial {
+	for !q.IsZero() {
+		_, r := p.Divide(q)
+		p, q = q, r
+	}
+	return p.Monic()
+}
+
+func (p Polynomial) PowMod(exponent *big.Int, modulus Polynomial) Polynomial {
+	_, base := p.Divide(modulus)
+	_, acc := Polynomial{coeffs: []FiniteFieldElement{One}}.Divide(modulus)
+	for i := exponent.BitLen() - 1; i >= 0; i-- {
+		_, acc = acc.Mul(acc).Divide(modulus)
+		if exponent.Bit(i) == 1 {
+			_, acc = acc.Mul(base).Divide(modulus)
+		}
+	}
+	return acc
+}
+
+// Roots returns the distinct roots of p in the field in ascending order.
+// Irreducible factors of degree greater than one contribute no roots, so for
+// a polynomial that splits over the field this is its full set of roots.
+func (p Polynomial) Roots() []FiniteFieldElement {
+	if p.Degree() < 1 {
+		return nil
+	}
+	x := Polynomial{coeffs: []FiniteFieldElement{Zero, One}}
+	// gcd(p, X^q - X) is the product of the distinct linear factors of p.
+	linear := p.Gcd(x.PowMod(p.LeadingCoeff().Field.Prime, p).Sub(x))
+	roots := splitRoots(linear)
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].Value.Cmp(roots[j].Value) < 0
+	})
+	return roots
+}
+
+// splitRoots finds the roots of a monic product of distinct linear factors by
+// equal-degree splitting: gcd(h, (X+a)^((q-1)/2) - 1) separates the roots r
+// for which r+a is a quadratic residue from the rest.
+func splitRoots(h Polynomial) []FiniteFieldElement {
+	switch h.Degree() {
+	case -1, 0:
+		return nil
+	case 1:
+		return []FiniteFieldElement{h.coeffs[0].Negate().Division(h.coeffs[1])}
+	}
+	field := h.LeadingCoeff().Field
+	exponent := new(big.Int).Rsh(new(big.Int).Sub(field.Prime, big.NewInt(1)), 1)
+	onePoly := Polynomial{coeffs: []FiniteFieldElement{One}}
+	for a := int64(0); ; a++ {
+		shift := newPolynomial([]FiniteFieldElement{field.NewFieldElement(big.NewInt(a)), One})
+		d := h.Gcd(shift.PowMod(exponent, h).Sub(onePoly))
+		if d.Degree() > 0 && d.Degree() < h.Degree() {
+			q, _ := h.Divide(d)
+			return append(splitRoots(d), splitRoots(q)...)
+		}
+	}
+}
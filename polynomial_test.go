@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func linearFactors(roots ...int64) Polynomial {
+	p := Polynomial{coeffs: []FiniteFieldElement{One}}
+	for _, r := range roots {
+		p = p.Mul(newPolynomial([]FiniteFieldElement{DefaultField.NewFieldElement(big.NewInt(-r)), One}))
+	}
+	return p
+}
+
+func checkRoots(t *testing.T, p Polynomial, want ...int64) {
+	t.Helper()
+	got := p.Roots()
+	if len(got) != len(want) {
+		t.Fatalf("got %d roots, want %v", len(got), want)
+	}
+	for i, r := range got {
+		if r.Value.Int64() != want[i] {
+			t.Errorf("root %d = %s, want %d", i, r.Value, want[i])
+		}
+	}
+}
+
+// Repeated roots are reported once, in ascending order.
+func TestRootsSplitting(t *testing.T) {
+	checkRoots(t, linearFactors(5, 3, 77777, 5, 0, 3221225472), 0, 3, 5, 77777, 3221225472)
+}
+
+// The generator 5 is not a square, so X^2 - 5 is irreducible and adds no
+// roots.
+func TestRootsIrreducibleQuadraticFactor(t *testing.T) {
+	quadratic := newPolynomial([]FiniteFieldElement{DefaultField.NewFieldElement(big.NewInt(-5)), Zero, One})
+	checkRoots(t, quadratic)
+	checkRoots(t, linearFactors(2, 9).Mul(quadratic), 2, 9)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+)
+
+// Poseidon parameters for the default field p = 3·2^30 + 1.
+//
+// The state has poseidonWidth elements, of which poseidonRate are absorbed
+// and squeezed and the rest are capacity. The S-box is x^5, the smallest
+// exponent coprime to p - 1. Each of the poseidonFullRounds full rounds
+// applies the S-box to every element and each of the poseidonPartialRounds
+// partial rounds to the first element only; half of the full rounds come
+// before the partial rounds and half after.
+//
+// The parameters are generated deterministically:
+//
+//   - Round constants: SHA-256 of poseidonSeed followed by a big-endian
+//     64-bit counter 0, 1, 2, ... yields a stream of 32-byte blocks. Each
+//     block is read as eight big-endian 32-bit words, words >= p are
+//     rejected, and the accepted words fill the constants round by round.
+//   - MDS matrix: the Cauchy matrix M[i][j] = 1 / (x_i + y_j) with x_i = i
+//     and y_j = poseidonWidth + j. The x_i are distinct, the y_j are distinct
+//     and no x_i + y_j is zero, so M is MDS.
+//
+// The round numbers target 128-bit security. The round-number script of the
+// Poseidon paper (calc_round_numbers.py, including the 2023/537 Groebner
+// bound) for t = 16, alpha = 5 and this 31.6-bit prime gives RF = 6, RP = 13
+// as the secure minimum and RF = 8, RP = 14 once its margins of two full
+// rounds and 7.5% partial rounds are added. We use RF = 8 and RP = 22. The
+// sponge's capacity of 8 elements, about 252 bits, caps generic collision
+// security at 126 bits.
+const (
+	poseidonWidth         = 16
+	poseidonRate          = 8
+	poseidonFullRounds    = 8
+	poseidonPartialRounds = 22
+	poseidonSeed          = "go-stark-prover/poseidon/p=3221225473/t=16/alpha=5/RF=8/RP=22"
+)
+
+// The permutation is specific to the default field, whose elements fit in 32
+// bits, so it works on uint64 residues instead of big.Int values.
+type poseidonParams struct {
+	prime          uint64
+	roundConstants [][poseidonWidth]uint64
+	mds            [poseidonWidth][poseidonWidth]uint64
+}
+
+var poseidon = newPoseidonParams()
+
+func newPoseidonParams() *poseidonParams {
+	params := &poseidonParams{
+		prime:          DefaultFieldSize.Uint64(),
+		roundConstants: make([][poseidonWidth]uint64, poseidonFullRounds+poseidonPartialRounds),
+	}
+
+	var words []uint32
+	var counter uint64
+	for r := range params.roundConstants {
+		for i := 0; i < poseidonWidth; i++ {
+			for len(words) == 0 {
+				block := sha256.Sum256(binary.BigEndian.AppendUint64([]byte(poseidonSeed), counter))
+				counter++
+				for j := 0; j < len(block); j += 4 {
+					if w := binary.BigEndian.Uint32(block[j:]); uint64(w) < params.prime {
+						words = append(words, w)
+					}
+				}
+			}
+			params.roundConstants[r][i] = uint64(words[0])
+			words = words[1:]
+		}
+	}
+
+	for i := 0; i < poseidonWidth; i++ {
+		for j := 0; j < poseidonWidth; j++ {
+			sum := DefaultField.NewFieldElement(big.NewInt(int64(i + poseidonWidth + j)))
+			params.mds[i][j] = sum.Inverse().Value.Uint64()
+		}
+	}
+	return params
+}
+
+func (params *poseidonParams) sbox(x uint64) uint64 {
+	x2 := x * x % params.prime
+	x4 := x2 * x2 % params.prime
+	return x4 * x % params.prime
+}
+
+func (params *poseidonParams) permute(state *[poseidonWidth]uint64) {
+	half := poseidonFullRounds / 2
+	for r, constants := range params.roundConstants {
+		for i := range state {
+			state[i] = (state[i] + constants[i]) % params.prime
+		}
+		if r < half || r >= half+poseidonPartialRounds {
+			for i := range state {
+				state[i] = params.sbox(state[i])
+			}
+		} else {
+			state[0] = params.sbox(state[0])
+		}
+		var mixed [poseidonWidth]uint64
+		for i := range mixed {
+			var acc uint64
+			for j := range state {
+				acc = (acc + params.mds[i][j]*state[j]) % params.prime
+			}
+			mixed[i] = acc
+		}
+		*state = mixed
+	}
+}
+
+// sponge absorbs elements, whose length must be a multiple of the rate, into
+// a state whose first capacity element holds mode, and squeezes one block.
+func (params *poseidonParams) sponge(elements []uint64, mode uint64) [poseidonRate]uint64 {
+	var state [poseidonWidth]uint64
+	state[poseidonRate] = mode
+	for len(elements) > 0 {
+		for i := 0; i < poseidonRate; i++ {
+			state[i] = (state[i] + elements[i]) % params.prime
+		}
+		params.permute(&state)
+		elements = elements[poseidonRate:]
+	}
+	var out [poseidonRate]uint64
+	copy(out[:], state[:poseidonRate])
+	return out
+}
+
+// PoseidonHashElements hashes field elements natively, without any byte
+// encoding, which is the form needed when the hash is arithmetised in an AIR.
+// The input is padded with a one and then zeros up to a multiple of the rate.
+func PoseidonHashElements(elements []FiniteFieldElement) [poseidonRate]FiniteFieldElement {
+	var out [poseidonRate]FiniteFieldElement
+	for i, v := range poseidon.sponge(poseidonPad(elements), 1) {
+		out[i] = DefaultField.NewFieldElement(new(big.Int).SetUint64(v))
+	}
+	return out
+}
+
+func poseidonPad(elements []FiniteFieldElement) []uint64 {
+	padded := make([]uint64, 0, len(elements)+poseidonRate)
+	for _, e := range elements {
+		padded = append(padded, e.Value.Uint64())
+	}
+	padded = append(padded, 1)
+	for len(padded)%poseidonRate != 0 {
+		padded = append(padded, 0)
+	}
+	return padded
+}
+
+// PoseidonHasher adapts Poseidon to the Hasher interface. The input bytes
+// are padded with 0x01 and then zeros to a multiple of 3·rate bytes and
+// packed three bytes per element, which keeps every element below p and the
+// encoding injective. The digest is the squeezed block of eight elements in
+// their canonical 4-byte encoding.
+// Merkle trees over PoseidonHasher bypass Hash and hash rows and digests as
+// field elements; see elementHasher.
+//
+// Test vectors:
+//
+//	Hash("")    = 7c9df86707ed7e0f2e1eefd50a4bddb059c43d88b4642a5450d3a2236715ce36
+//	Hash("abc") = 3347c3bf71147eb9670022d439483e683feecd83b90f11c371693a9b435029b7
+type PoseidonHasher struct{}
+
+func (PoseidonHasher) Name() string { return "poseidon" }
+
+func (PoseidonHasher) Hash(data []byte) Digest {
+	padded := append(append([]byte{}, data...), 0x01)
+	for len(padded)%(3*poseidonRate) != 0 {
+		padded = append(padded, 0x00)
+	}
+	elements := make([]uint64, len(padded)/3)
+	for i := range elements {
+		elements[i] = uint64(padded[3*i])<<16 | uint64(padded[3*i+1])<<8 | uint64(padded[3*i+2])
+	}
+
+	var digest Digest
+	for i, v := range poseidon.sponge(elements, 0) {
+		binary.BigEndian.PutUint32(digest[4*i:], uint32(v))
+	}
+	return digest
+}
+
+// hashElements is the field-native hash of Merkle trees over Poseidon: the
+// elements are padded as in PoseidonHashElements and the Merkle prefix, plus
+// 2 to keep clear of Hash and PoseidonHashElements, goes in the capacity.
+func (PoseidonHasher) hashElements(prefix byte, elements []FiniteFieldElement) Digest {
+	var digest Digest
+	for i, v := range poseidon.sponge(poseidonPad(elements), 2+uint64(prefix)) {
+		binary.BigEndian.PutUint32(digest[4*i:], uint32(v))
+	}
+	return digest
+}
+
+// digestElements reads a digest as its eight 4-byte words. The words of a
+// Poseidon digest are already field elements; those of a random salt are
+// reduced, which still leaves well over 128 bits of entropy.
+func (PoseidonHasher) digestElements(d Digest) []FiniteFieldElement {
+	elements := make([]FiniteFieldElement, poseidonRate)
+	for i := range elements {
+		word := binary.BigEndian.Uint32(d[4*i:])
+		elements[i] = DefaultField.NewFieldElement(new(big.Int).SetUint64(uint64(word)))
+	}
+	return elements
+}
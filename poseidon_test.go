@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+)
+
+func TestPoseidonHasherVectors(t *testing.T) {
+	vectors := []struct {
+		input, digest string
+	}{
+		{"", "7c9df86707ed7e0f2e1eefd50a4bddb059c43d88b4642a5450d3a2236715ce36"},
+		{"abc", "3347c3bf71147eb9670022d439483e683feecd83b90f11c371693a9b435029b7"},
+	}
+	for _, v := range vectors {
+		if got := (PoseidonHasher{}).Hash([]byte(v.input)).String(); got != v.digest {
+			t.Errorf("Hash(%q) = %s, want %s", v.input, got, v.digest)
+		}
+	}
+}
+
+func TestPoseidonHashElementsVector(t *testing.T) {
+	input := []FiniteFieldElement{
+		DefaultField.NewFieldElement(big.NewInt(1)),
+		DefaultField.NewFieldElement(big.NewInt(2)),
+		DefaultField.NewFieldElement(big.NewInt(3)),
+	}
+	want := []int64{103088406, 3200511012, 1447277090, 526069685, 2216042714, 1725822797, 153858746, 1612803174}
+	for i, v := range PoseidonHashElements(input) {
+		if v.Value.Int64() != want[i] {
+			t.Errorf("element %d = %s, want %d", i, v.Value, want[i])
+		}
+	}
+}
+
+// A Poseidon tree node is the sponge over the sixteen words of its children
+// with the node tag in the capacity, so an AIR can recompute it without
+// decomposing anything into bytes.
+func TestPoseidonMerkleNodeIsFieldNative(t *testing.T) {
+	left := (PoseidonHasher{}).Hash([]byte("left"))
+	right := (PoseidonHasher{}).Hash([]byte("right"))
+	var words []uint64
+	for _, d := range []Digest{left, right} {
+		for i := 0; i < poseidonRate; i++ {
+			words = append(words, uint64(binary.BigEndian.Uint32(d[4*i:])))
+		}
+	}
+	words = append(words, 1, 0, 0, 0, 0, 0, 0, 0)
+	var want Digest
+	for i, v := range poseidon.sponge(words, 2+uint64(nodePrefix)) {
+		binary.BigEndian.PutUint32(want[4*i:], uint32(v))
+	}
+	if got := hashNode(PoseidonHasher{}, left, right); got != want {
+		t.Fatalf("node = %s, want %s", got, want)
+	}
+}
+
+func TestPoseidonCommitmentOpens(t *testing.T) {
+	column := make(MemoryElementStorage, 13)
+	for i := range column {
+		column[i] = DefaultField.NewFieldElement(big.NewInt(int64(i*i + 1)))
+	}
+	for _, salted := range []bool{false, true} {
+		vc := MerkleCommitment{Hasher: PoseidonHasher{}, CapHeight: 1, Salted: salted}
+		committed := CommitColumn(vc, column)
+		rows := [][]FiniteFieldElement{{column[2]}, {column[12]}}
+		if !vc.Verify(committed.Commitment(), committed.Open([]int{2, 12}), rows) {
+			t.Errorf("salted %v: opening does not verify", salted)
+		}
+		rows[1] = []FiniteFieldElement{column[11]}
+		if vc.Verify(committed.Commitment(), committed.Open([]int{2, 12}), rows) {
+			t.Errorf("salted %v: wrong row verifies", salted)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+)
+
+// DigestStorage holds the nodes of a Merkle tree. Slice returns the digests
+// from start on as a storage of the same kind.
+type DigestStorage interface {
+	Len() int
+	Get(i int) Digest
+	Set(i int, d Digest)
+	Slice(start int) DigestStorage
+}
+
+// ElementStorage holds a vector of field elements, such as an LDE column or
+// a FRI layer.
+type ElementStorage interface {
+	Len() int
+	Get(i int) FiniteFieldElement
+	Set(i int, v FiniteFieldElement)
+}
+
+// RowStorage is the leaf data of a Merkle tree, one row per leaf.
+type RowStorage interface {
+	Len() int
+	Row(i int) []FiniteFieldElement
+}
+
+type MemoryDigestStorage []Digest
+
+func (s MemoryDigestStorage) Len() int            { return len(s) }
+func (s MemoryDigestStorage) Get(i int) Digest    { return s[i] }
+func (s MemoryDigestStorage) Set(i int, d Digest) { s[i] = d }
+
+// Slice copies the digests, so that pruning a tree releases the nodes it
+// drops instead of keeping the whole array reachable.
+func (s MemoryDigestStorage) Slice(start int) DigestStorage {
+	return append(MemoryDigestStorage(nil), s[start:]...)
+}
+
+type MemoryElementStorage []FiniteFieldElement
+
+func (s MemoryElementStorage) Len() int                        { return len(s) }
+func (s MemoryElementStorage) Get(i int) FiniteFieldElement    { return s[i] }
+func (s MemoryElementStorage) Set(i int, v FiniteFieldElement) { s[i] = v }
+
+type MemoryRows [][]FiniteFieldElement
+
+func (r MemoryRows) Len() int                       { return len(r) }
+func (r MemoryRows) Row(i int) []FiniteFieldElement { return r[i] }
+
+// StoredRows reads rows of Width consecutive elements from Values, so row i
+// is Values[i·Width : (i+1)·Width].
+type StoredRows struct {
+	Values ElementStorage
+	Width  int
+}
+
+func (r StoredRows) Len() int {
+	return r.Values.Len() / r.Width
+}
+
+func (r StoredRows) Row(i int) []FiniteFieldElement {
+	row := make([]FiniteFieldElement, r.Width)
+	for j := range row {
+		row[j] = r.Values.Get(i*r.Width + j)
+	}
+	return row
+}
+
+// FileDigestStorage keeps digests in a file, reading and writing each one
+// with a positioned I/O call, so only the nodes a query touches are read.
+// I/O errors after creation are unrecoverable for the prover and panic.
+type FileDigestStorage struct {
+	file   *os.File
+	offset int
+	n      int
+}
+
+func NewFileDigestStorage(path string, n int) (*FileDigestStorage, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(int64(n) * int64(len(Digest{}))); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileDigestStorage{file: file, n: n}, nil
+}
+
+func (s *FileDigestStorage) Len() int { return s.n }
+
+func (s *FileDigestStorage) Get(i int) Digest {
+	var d Digest
+	if _, err := s.file.ReadAt(d[:], int64(s.offset+i)*int64(len(d))); err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (s *FileDigestStorage) Set(i int, d Digest) {
+	if _, err := s.file.WriteAt(d[:], int64(s.offset+i)*int64(len(d))); err != nil {
+		panic(err)
+	}
+}
+
+// Slice shares the file, which stays open until the original is closed.
+func (s *FileDigestStorage) Slice(start int) DigestStorage {
+	return &FileDigestStorage{file: s.file, offset: s.offset + start, n: s.n - start}
+}
+
+func (s *FileDigestStorage) Close() error {
+	return s.file.Close()
+}
+
+// FileElementStorage keeps field elements of the default field in a file in
+// their canonical fixed-width encoding, so that an LDE column or a FRI layer
+// too large for memory can be committed to and opened from disk.
+type FileElementStorage struct {
+	file  *os.File
+	n     int
+	width int
+}
+
+func NewFileElementStorage(path string, n int) (*FileElementStorage, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	width := DefaultField.ElementSize()
+	if err := file.Truncate(int64(n) * int64(width)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileElementStorage{file: file, n: n, width: width}, nil
+}
+
+func (s *FileElementStorage) Len() int { return s.n }
+
+func (s *FileElementStorage) Get(i int) FiniteFieldElement {
+	buf := make([]byte, s.width)
+	if _, err := s.file.ReadAt(buf, int64(i)*int64(s.width)); err != nil {
+		panic(err)
+	}
+	v, ok := DefaultField.ElementFromBytes(buf)
+	if !ok {
+		panic("stored field element is not canonical")
+	}
+	return v
+}
+
+func (s *FileElementStorage) Set(i int, v FiniteFieldElement) {
+	if _, err := s.file.WriteAt(v.Bytes(), int64(i)*int64(s.width)); err != nil {
+		panic(err)
+	}
+}
+
+func (s *FileElementStorage) Close() error {
+	return s.file.Close()
+}
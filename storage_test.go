@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileElementStorageRoundTrip(t *testing.T) {
+	s, err := NewFileElementStorage(filepath.Join(t.TempDir(), "values"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < s.Len(); i++ {
+		s.Set(i, DefaultField.NewFieldElement(big.NewInt(int64(i)*123456789)))
+	}
+	for i := 0; i < s.Len(); i++ {
+		if want := DefaultField.NewFieldElement(big.NewInt(int64(i) * 123456789)); !s.Get(i).IsEqual(want) {
+			t.Fatalf("element %d = %s, want %s", i, s.Get(i).Value, want.Value)
+		}
+	}
+}
+
+func TestFileDigestStorageRoundTrip(t *testing.T) {
+	s, err := NewFileDigestStorage(filepath.Join(t.TempDir(), "nodes"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < s.Len(); i++ {
+		s.Set(i, Digest{byte(i), 0xff})
+	}
+	tail := s.Slice(4)
+	if tail.Len() != 6 || tail.Get(0) != (Digest{4, 0xff}) {
+		t.Fatal("slice does not start at digest 4")
+	}
+	tail.Set(1, Digest{0xaa})
+	if s.Get(5) != (Digest{0xaa}) {
+		t.Fatal("slice does not share the file")
+	}
+}
+
+// Slicing in-memory nodes copies them, so a pruned tree does not keep the
+// dropped levels reachable through a shared array.
+func TestMemoryDigestStorageSliceCopies(t *testing.T) {
+	s := make(MemoryDigestStorage, 100)
+	s[90] = Digest{0x90}
+	tail := s.Slice(90).(MemoryDigestStorage)
+	if len(tail) != 10 || tail[0] != (Digest{0x90}) {
+		t.Fatalf("slice has length %d, want 10", len(tail))
+	}
+	tail[1] = Digest{0xaa}
+	if s[91] == (Digest{0xaa}) {
+		t.Fatal("slice shares the original array")
+	}
+
+	tree := NewRowMerkleTree(merkleTestRows(64), SHA256Hasher{})
+	original := tree.nodes.(MemoryDigestStorage)
+	tree.Prune(3)
+	nodes := tree.nodes.(MemoryDigestStorage)
+	if want := MerkleNodeCount(64) - 64 - 32 - 16; len(nodes) != want {
+		t.Fatalf("pruned tree retains %d nodes, want %d", len(nodes), want)
+	}
+	original[len(original)-1] = Digest{0xaa}
+	if nodes[len(nodes)-1] == (Digest{0xaa}) {
+		t.Fatal("pruned tree shares the original node array")
+	}
+}
+
+// A tree over file-backed rows and nodes matches the in-memory tree, before
+// and after pruning, and stays file-backed.
+func TestStoredMerkleTreeMatchesMemory(t *testing.T) {
+	dir := t.TempDir()
+	rows := merkleTestRows(300)
+	values, err := NewFileElementStorage(filepath.Join(dir, "values"), len(rows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer values.Close()
+	for i, row := range rows {
+		values.Set(i, row[0])
+	}
+	nodes, err := NewFileDigestStorage(filepath.Join(dir, "nodes"), MerkleNodeCount(len(rows)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodes.Close()
+
+	stored := NewStoredMerkleTree(StoredRows{Values: values, Width: 1}, nodes, SHA256Hasher{}, 4)
+	memory := NewRowMerkleTree(rows, SHA256Hasher{})
+	if stored.Root() != memory.Root() {
+		t.Fatal("roots differ")
+	}
+	stored.Prune(3)
+	if _, ok := stored.nodes.(*FileDigestStorage); !ok {
+		t.Fatalf("pruned nodes moved to %T", stored.nodes)
+	}
+	row, path := stored.Open(123)
+	if !VerifyRowMerkleProof(path, row, memory.Root(), SHA256Hasher{}) {
+		t.Fatal("opening of pruned stored tree does not verify")
+	}
+}
+
+// A FRI layer committed to from a file opens like the same layer in memory.
+func TestFileBackedFRILayer(t *testing.T) {
+	layer := make([]FiniteFieldElement, 256)
+	for i := range layer {
+		layer[i] = DefaultField.NewFieldElement(big.NewInt(int64(i*i + 5)))
+	}
+	stored, err := NewFileElementStorage(filepath.Join(t.TempDir(), "layer"), len(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stored.Close()
+	for i, v := range layer {
+		stored.Set(i, v)
+	}
+
+	vc := MerkleCommitment{Hasher: SHA256Hasher{}, CapHeight: MerkleCapHeight}
+	fromFile := CommitFRILayer(vc, stored)
+	fromMemory := CommitFRILayer(vc, MemoryElementStorage(layer))
+	if fromFile.Commitment() != fromMemory.Commitment() {
+		t.Fatal("commitments differ")
+	}
+	cosets := friCosets{stored}
+	rows := [][]FiniteFieldElement{cosets.Row(5), cosets.Row(60)}
+	if !vc.Verify(fromMemory.Commitment(), fromFile.Open([]int{5, 60}), rows) {
+		t.Fatal("opening of file-backed layer does not verify")
+	}
+}